@@ -26,10 +26,14 @@ import (
 // pmWikiDir and gitDir are the directories to be used for PmWiki input and git output.
 var pmWikiDir, gitDir string
 
+// charset of PmWiki's page files. If empty, each page file's charset field will be used.
+var charset string
+
 // init handles the setup; flag parsing and the like.
 func init() {
 	flag.StringVar(&pmWikiDir, "pmwiki", "", "path of PmWiki's wiki.d directory")
 	flag.StringVar(&gitDir, "git", "", "path to the output git repository")
+	flag.StringVar(&charset, "charset", "", "charset of PmWiki's page files, e.g., ISO-8859-1; defaults to each page's charset")
 
 	flag.Parse()
 
@@ -38,6 +42,10 @@ func init() {
 		os.Exit(1)
 	}
 
+	if charset != "" && !pmwiki.CharsetSupported(charset) {
+		log.WithField("charset", charset).Fatal("Charset is not supported")
+	}
+
 	for _, dir := range []string{pmWikiDir, gitDir} {
 		if stat, err := os.Stat(dir); os.IsNotExist(err) {
 			log.WithField("directory", dir).Fatal("Directory does not exist")
@@ -51,6 +59,11 @@ func init() {
 func pmWikiRevisions() (revs []pmwiki.PageFile) {
 	isDeleted := regexp.MustCompile(`.*,del-(\d+)$`)
 
+	charsetOpt := pmwiki.WithAutoCharset()
+	if charset != "" {
+		charsetOpt = pmwiki.WithCharset(charset)
+	}
+
 	fs, err := ioutil.ReadDir(pmWikiDir)
 	if err != nil {
 		log.WithField("pmwiki", pmWikiDir).WithError(err).Fatal("Cannot read PmWiki directory")
@@ -68,11 +81,14 @@ func pmWikiRevisions() (revs []pmwiki.PageFile) {
 			logger.WithError(err).Fatal("Cannot open file")
 		}
 
-		pageFile, err := pmwiki.ParsePageFile(r)
+		pageFile, err := pmwiki.ParsePageFile(r, charsetOpt)
 		if err != nil {
 			logger.WithError(err).Error("Cannot parse page file")
 			goto fail
 		}
+		if charset == "" && pageFile.Charset != "" && !pmwiki.CharsetSupported(pageFile.Charset) {
+			logger.WithField("charset", pageFile.Charset).Warn("Page file's charset is not supported, kept unconverted")
+		}
 
 		if matches := isDeleted.FindStringSubmatch(f.Name()); len(matches) > 0 {
 			if unixInt, err := strconv.ParseInt(matches[1], 10, 64); err != nil {
@@ -83,8 +99,14 @@ func pmWikiRevisions() (revs []pmwiki.PageFile) {
 			}
 		}
 
-		if err := pageFile.Revisions(func(pf pmwiki.PageFile) { revs = append(revs, pf) }); err != nil {
-			logger.WithError(err).Error("Cannot parse page file revisions")
+		if err := pageFile.RevisionsLenient(func(pf pmwiki.PageFile) {
+			if pf.Gap {
+				logger.WithField("revision", pf.Time).Warn("Skipping revision of unknown text")
+				return
+			}
+			revs = append(revs, pf)
+		}); err != nil {
+			logger.WithError(err).Warn("Page file's history is inconsistent, kept as much as possible")
 		}
 
 	fail:
@@ -131,6 +153,9 @@ func createCommit(revision pmwiki.PageFile) error {
 		}
 
 		message = fmt.Sprintf("Modified %s", revision.Name)
+		if revision.Minor {
+			message += " (minor edit)"
+		}
 	} else {
 		// Delete the file
 		if _, err := os.Stat(filename); os.IsNotExist(err) {
@@ -149,6 +174,10 @@ func createCommit(revision pmwiki.PageFile) error {
 		message = fmt.Sprintf("Deleted %s", revision.Name)
 	}
 
+	if revision.Summary != "" {
+		message += "\n\n" + revision.Summary
+	}
+
 	statusCmd := exec.Command("git", "-C", gitDir, "status", "--porcelain")
 	if out, err := statusCmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("git status errored: %w, %s", err, out)
@@ -181,7 +210,9 @@ func main() {
 	revs := pmWikiRevisions()
 	log.WithField("revisions", len(revs)).Info("Finished parsing revisions")
 
-	sort.Sort(pmwiki.ByTime(revs))
+	// Revisions are ordered by their time. Within the same second, different pages are ordered by their name and
+	// revisions of the same page by their Rev. Revisions equal in all three keep their parsed order.
+	sort.SliceStable(revs, pmwiki.ByTime(revs).Less)
 
 	for _, rev := range revs {
 		if err := createCommit(rev); err != nil {
@@ -7,18 +7,25 @@ package pmwiki
 import (
 	"fmt"
 	"net"
-	"strings"
+	"sort"
 	"time"
 )
 
 // PageFileRevision describes a PageFile internal diff.
 type PageFileRevision struct {
-	Time   time.Time
-	Author string
-	Host   net.IP
+	Time    time.Time
+	Author  string
+	Host    net.IP
+	Summary string
+	Minor   bool
 
+	// Diff is the parsed reverse diff against DiffAgainst. It is nil if its parsing was deferred by WithLazyDiffs or
+	// skipped by WithHeaderOnly. Thus, Patch and RawDiff should be used for parsed page files.
 	Diff        Patch
 	DiffAgainst time.Time
+
+	// lazyDiff is the raw diff, if its parsing was deferred by WithLazyDiffs. Diff is empty in the meantime.
+	lazyDiff *pageFileLazyDiff
 }
 
 // PageFile describes a PmWiki page including its history.
@@ -32,26 +39,162 @@ type PageFile struct {
 	Host   net.IP
 	Rev    int
 
-	Revs map[time.Time]PageFileRevision
+	// Additional metadata, as being stored by PmWiki.
+	CreateTime  time.Time
+	Summary     string
+	Agent       string
+	Targets     []string
+	Title       string
+	Description string
+	Keywords    string
+	Charset     string
+	UpdatedTo   string
+	UpdatedBy   string
+
+	// Revs are ordered from the newest to the oldest revision. Multiple revisions might share the same second.
+	Revs []PageFileRevision
+
+	// Minor is only set within a revision's view, see Revisions.
+	Minor bool
+	// Gap is only set within a revision's view of RevisionsLenient, marking a revision whose text is unknown.
+	Gap bool
+
+	// Unknown fields, which are not supported by this library, are kept in their original order to be written back.
+	Unknown []PageFileField
 
 	Deleted time.Time
+
+	// present marks the optional fields of a parsed page file to be written back, even if being empty.
+	present map[string]bool
+
+	// decodedCharset is the charset this PageFile was converted from by ParsePageFile, to be converted back by
+	// WritePageFile unless another charset is requested.
+	decodedCharset string
+
+	// texts caches reconstructed texts of older revisions, see TextAtRev.
+	texts *pageFileTexts
+
+	// headerOnly marks a PageFile parsed by WithHeaderOnly, lacking its text and diffs.
+	headerOnly bool
+}
+
+// pageFileStringFields are the keys of a PageFile's optional string fields.
+var pageFileStringFields = []string{"agent", "charset", "csum", "description", "keywords", "title", "updatedby", "updatedto"}
+
+// stringField returns a pointer to the optional string field of the given key or nil, if there is no such field.
+func (pageFile *PageFile) stringField(key string) *string {
+	switch key {
+	case "agent":
+		return &pageFile.Agent
+	case "charset":
+		return &pageFile.Charset
+	case "csum":
+		return &pageFile.Summary
+	case "description":
+		return &pageFile.Description
+	case "keywords":
+		return &pageFile.Keywords
+	case "title":
+		return &pageFile.Title
+	case "updatedby":
+		return &pageFile.UpdatedBy
+	case "updatedto":
+		return &pageFile.UpdatedTo
+	default:
+		return nil
+	}
+}
+
+// PageFileField is a single key-value line of a page file, e.g., "diff:1603451578:1603450377:=...".
+type PageFileField struct {
+	Key   string
+	Opts  []string
+	Value string
+}
+
+// Revision returns the newest revision at the given time.
+func (pageFile PageFile) Revision(at time.Time) (PageFileRevision, bool) {
+	if i := pageFile.revisionIndex(at, 0); i >= 0 {
+		return pageFile.Revs[i], true
+	}
+	return PageFileRevision{}, false
+}
+
+// revisionIndex returns the index of the first revision at the given time, starting the search at offset, or -1.
+func (pageFile PageFile) revisionIndex(at time.Time, offset int) int {
+	for i := offset; i < len(pageFile.Revs); i++ {
+		if pageFile.Revs[i].Time.Equal(at) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortRevisions orders the Revs from the newest to the oldest revision.
+//
+// Revisions within the same second are ordered by their DiffAgainst. A later save within the same second is diffed
+// against this second, while the earlier one is diffed against an older revision.
+func (pageFile *PageFile) sortRevisions() {
+	sort.SliceStable(pageFile.Revs, func(i, j int) bool {
+		a, b := pageFile.Revs[i], pageFile.Revs[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.After(b.Time)
+		}
+		return a.DiffAgainst.After(b.DiffAgainst)
+	})
+}
+
+// deletedView is the final view of a deleted PageFile, see Revisions.
+func (pageFile PageFile) deletedView() PageFile {
+	return PageFile{
+		Version: pageFile.Version,
+		Name:    pageFile.Name,
+		Time:    pageFile.Deleted,
+		Text:    "",
+		Author:  "", // the real author is written in the RecentChanges file
+		Host:    net.ParseIP("::1"),
+		Rev:     pageFile.Rev + 1,
+	}
+}
+
+// revisionView is the view of a single revision with its text, see Revisions.
+func (pageFile PageFile) revisionView(rev PageFileRevision, text string, revNo int) PageFile {
+	return PageFile{
+		Version: pageFile.Version,
+		Name:    pageFile.Name,
+		Time:    rev.Time,
+		Text:    text,
+		Author:  rev.Author,
+		Host:    rev.Host,
+		Rev:     revNo,
+		Summary: rev.Summary,
+		Minor:   rev.Minor,
+	}
+}
+
+// checkContent returns an error for a PageFile parsed by WithHeaderOnly, as its text and diffs are missing.
+func (pageFile *PageFile) checkContent() error {
+	if pageFile.headerOnly {
+		return fmt.Errorf("page file %s was parsed without its text and diffs", pageFile.Name)
+	}
+	return nil
 }
 
 // Revisions calls a function with a "view" copy of each revision of this PageFile.
 //
-// An error is returned when creating the successive revision fails. However, multiple previous revisions could be
-// generated previously.
+// The DiffAgainst chain is followed until the page's creation. Thus, revisions with an empty text, e.g., a blanked
+// page which was restored later, are passed as views with an empty Text as well.
+//
+// An error is returned for an invalid history, as reported by ValidateHistory, or when creating the successive
+// revision fails. However, multiple previous revisions could be generated previously. RevisionsLenient continues in
+// such cases.
 func (pageFile PageFile) Revisions(callback func(view PageFile)) error {
+	if err := pageFile.checkContent(); err != nil {
+		return err
+	}
+
 	if pageFile.Deleted != (time.Time{}) {
-		defer callback(PageFile{
-			Version: pageFile.Version,
-			Name:    pageFile.Name,
-			Time:    pageFile.Deleted,
-			Text:    "",
-			Author:  "", // the real author is written in the RecentChanges file
-			Host:    net.ParseIP("::1"),
-			Rev:     pageFile.Rev + 1,
-		})
+		defer callback(pageFile.deletedView())
 	}
 
 	if len(pageFile.Revs) == 0 {
@@ -59,33 +202,86 @@ func (pageFile PageFile) Revisions(callback func(view PageFile)) error {
 		return nil
 	}
 
+	// Dangling references are checked while walking, as the chain's end might be an expired revision.
+	for _, err := range pageFile.ValidateHistory() {
+		if err.Kind != HistoryDangling {
+			return err
+		}
+	}
+
 	text := pageFile.Text
 	revNo := pageFile.Rev
 
-	for rev, ok := pageFile.Revs[pageFile.Time]; text != ""; rev, ok = pageFile.Revs[rev.DiffAgainst] {
-		if !ok {
+	for i := pageFile.revisionIndex(pageFile.Time, 0); ; {
+		if i < 0 {
 			return fmt.Errorf("revision %d is missing", revNo)
 		}
+		rev := pageFile.Revs[i]
 
-		curr := PageFile{
-			Version: pageFile.Version,
-			Name:    pageFile.Name,
-			Time:    rev.Time,
-			Text:    text,
-			Author:  rev.Author,
-			Host:    rev.Host,
-			Rev:     revNo,
-		}
-		callback(curr)
-
+		callback(pageFile.revisionView(rev, text, revNo))
 		revNo--
 
-		var textOut strings.Builder
-		if err := rev.Diff.Apply(strings.NewReader(text), &textOut); err != nil {
+		var err error
+		if text, err = rev.applyDiff(text); err != nil {
 			return err
 		}
-		text = textOut.String()
+
+		i = pageFile.diffTarget(i)
+
+		// The page's creation is either diffed against itself or, for older page files, against an empty text.
+		if i < 0 && (rev.DiffAgainst.Equal(rev.Time) || text == "") {
+			return nil
+		}
 	}
+}
+
+// AddRevision changes this PageFile's text to a new revision, as PmWiki does when saving a page.
+//
+// The current text is kept within Revs as a reverse diff against the new text. The first revision of a new page is
+// diffed against itself, just like PmWiki does.
+func (pageFile *PageFile) AddRevision(newText, author string, host net.IP, at time.Time, summary string) error {
+	at = time.Unix(at.Unix(), 0).UTC()
+
+	if err := pageFile.checkContent(); err != nil {
+		return err
+	}
+	if at.Before(pageFile.Time) {
+		return fmt.Errorf("revision %v is older than the current revision %v", at, pageFile.Time)
+	}
+
+	diffAgainst := pageFile.Time
+	if diffAgainst == (time.Time{}) {
+		diffAgainst = at
+	}
+
+	for _, rev := range pageFile.Revs {
+		if rev.Time.Equal(at) && rev.DiffAgainst.Equal(diffAgainst) {
+			return fmt.Errorf("revision %v against %v already exists", at, diffAgainst)
+		}
+	}
+
+	pageFile.Revs = append([]PageFileRevision{{
+		Time:        at,
+		Author:      author,
+		Host:        host,
+		Summary:     summary,
+		Diff:        Diff(newText, pageFile.Text),
+		DiffAgainst: diffAgainst,
+	}}, pageFile.Revs...)
+
+	if pageFile.CreateTime == (time.Time{}) {
+		pageFile.CreateTime = at
+	}
+
+	pageFile.Time = at
+	pageFile.Text = newText
+	pageFile.Author = author
+	pageFile.Host = host
+	pageFile.Summary = summary
+	pageFile.Rev++
+
+	// The cached texts belong to the previous state, which might still be used by copies of this PageFile.
+	pageFile.texts = &pageFileTexts{}
 
 	return nil
 }
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"strings"
+)
+
+// charsetConverter converts a string from or to UTF-8.
+type charsetConverter func(string) (string, error)
+
+// charsetDecodeLatin1 converts an ISO-8859-1 string to UTF-8.
+func charsetDecodeLatin1(s string) (string, error) {
+	var builder strings.Builder
+	builder.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		builder.WriteRune(rune(s[i]))
+	}
+	return builder.String(), nil
+}
+
+// charsetEncodeLatin1 converts an UTF-8 string to ISO-8859-1.
+func charsetEncodeLatin1(s string) (string, error) {
+	var builder strings.Builder
+	builder.Grow(len(s))
+
+	for i, r := range s {
+		if r > 0xff {
+			return "", fmt.Errorf("rune %q at position %d cannot be encoded in ISO-8859-1", r, i)
+		}
+		builder.WriteByte(byte(r))
+	}
+	return builder.String(), nil
+}
+
+// charsetConverters returns the decoder and encoder for a charset. For UTF-8, both are nil.
+func charsetConverters(charset string) (decoder, encoder charsetConverter, err error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return nil, nil, nil
+
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "latin-1":
+		return charsetDecodeLatin1, charsetEncodeLatin1, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+}
+
+// CharsetSupported checks if a charset can be converted by WithCharset or WithAutoCharset.
+func CharsetSupported(charset string) bool {
+	_, _, err := charsetConverters(charset)
+	return err == nil
+}
+
+// convertStrings of a slice into a new slice.
+func convertStrings(conv charsetConverter, in []string) (out []string, err error) {
+	if in == nil {
+		return nil, nil
+	}
+
+	out = make([]string, len(in))
+	for i := range in {
+		if out[i], err = conv(in[i]); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// convert all texts of this Patch into a new Patch.
+func (patch Patch) convert(conv charsetConverter) (out Patch, err error) {
+	if patch == nil {
+		return nil, nil
+	}
+
+	out = make(Patch, len(patch))
+	for i, patchAction := range patch {
+		out[i] = patchAction
+		if out[i].additionLines, err = convertStrings(conv, patchAction.additionLines); err != nil {
+			return nil, err
+		}
+		if out[i].deletionLines, err = convertStrings(conv, patchAction.deletionLines); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// convert all texts of this PageFile, including its revisions, into a new PageFile.
+func (pageFile PageFile) convert(conv charsetConverter) (PageFile, error) {
+	var err error
+	convStr := func(s *string) {
+		if err == nil {
+			*s, err = conv(*s)
+		}
+	}
+
+	for _, s := range []*string{&pageFile.Name, &pageFile.Text, &pageFile.Author} {
+		convStr(s)
+	}
+	for _, key := range pageFileStringFields {
+		convStr(pageFile.stringField(key))
+	}
+	if err != nil {
+		return PageFile{}, err
+	}
+
+	if pageFile.Targets, err = convertStrings(conv, pageFile.Targets); err != nil {
+		return PageFile{}, err
+	}
+
+	if pageFile.Revs != nil {
+		revs := make([]PageFileRevision, len(pageFile.Revs))
+		for i, rev := range pageFile.Revs {
+			convStr(&rev.Author)
+			convStr(&rev.Summary)
+			if err != nil {
+				return PageFile{}, err
+			}
+			if rev.Diff, err = rev.Diff.convert(conv); err != nil {
+				return PageFile{}, err
+			}
+			if rev.lazyDiff != nil {
+				rev.lazyDiff = rev.lazyDiff.converted(conv)
+			}
+			revs[i] = rev
+		}
+		pageFile.Revs = revs
+	}
+
+	if pageFile.Unknown != nil {
+		unknown := make([]PageFileField, len(pageFile.Unknown))
+		for i, field := range pageFile.Unknown {
+			convStr(&field.Value)
+			unknown[i] = field
+		}
+		pageFile.Unknown = unknown
+	}
+
+	// The cached texts are not converted.
+	pageFile.texts = &pageFileTexts{}
+
+	return pageFile, err
+}
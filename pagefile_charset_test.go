@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageFileCharsetRoundTrip(t *testing.T) {
+	input := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\nauthor=J\xfcrgen\ncharset=ISO-8859-1\ncsum=Gr\xfc\xdfe\n" +
+		"name=Main.Test\nrev=1\ntargets=Main.F\xfc\xfc\ntext=Gr\xfc\xdfe%0aWelt\ntime=20\ntitle=\xc4rger\n" +
+		"author:20=J\xfcrgen\ncsum:20=Gr\xfc\xdfe\ndiff:20:20:=1,2d0%0a%3c Gr\xfc\xdfe%0a%3c Welt%0a\\ No newline at end of file%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input), WithAutoCharset())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev, _ := pf.Revision(time.Unix(20, 0).UTC())
+
+	checks := []struct {
+		name     string
+		val      string
+		expected string
+	}{
+		{"author", pf.Author, "Jürgen"},
+		{"summary", pf.Summary, "Grüße"},
+		{"text", pf.Text, "Grüße\nWelt"},
+		{"title", pf.Title, "Ärger"},
+		{"targets", pf.Targets[0], "Main.Füü"},
+		{"revision author", rev.Author, "Jürgen"},
+		{"revision summary", rev.Summary, "Grüße"},
+		{"revision diff", rev.Diff[0].deletionLines[0], "Grüße"},
+	}
+	for _, check := range checks {
+		if check.val != check.expected {
+			t.Fatalf("%s: %q != %q", check.name, check.val, check.expected)
+		}
+	}
+
+	pfCopy, _ := ParsePageFile(strings.NewReader(input), WithAutoCharset())
+
+	// The converted PageFile is written back in its charset, even without an option.
+	for _, opts := range [][]PageFileOption{{WithAutoCharset()}, nil} {
+		var out strings.Builder
+		if err := WritePageFile(&out, pf, opts...); err != nil {
+			t.Fatal(err)
+		} else if out.String() != input {
+			t.Fatalf("expected:\n%q\ngot:\n%q", input, out.String())
+		}
+	}
+
+	utf8 := pf
+	utf8.Charset = "UTF-8"
+
+	var out strings.Builder
+	if err := WritePageFile(&out, utf8); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(out.String(), "\ncharset=UTF-8\n") || !strings.Contains(out.String(), "\ntext=Grüße%0aWelt\n") {
+		t.Fatalf("values were not written in UTF-8:\n%q", out.String())
+	}
+
+	if !reflect.DeepEqual(pf, pfCopy) {
+		t.Fatal("writing altered the PageFile")
+	}
+}
+
+func TestPageFileCharsetOptions(t *testing.T) {
+	latin1 := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ntext=Gr\xfc\xdfe\n"
+	latin1Field := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ncharset=ISO-8859-1\ntext=Gr\xfc\xdfe\n"
+	utf8Field := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ncharset=UTF-8\ntext=Grüße\n"
+	koi8Field := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ncharset=KOI8-R\ntext=\xf0\xd2\xc9\xd7\xc5\xd4\n"
+
+	tests := []struct {
+		name  string
+		input string
+		opts  []PageFileOption
+		text  string
+	}{
+		{"no conversion", latin1Field, nil, "Gr\xfc\xdfe"},
+		{"auto without field", latin1, []PageFileOption{WithAutoCharset()}, "Gr\xfc\xdfe"},
+		{"auto", latin1Field, []PageFileOption{WithAutoCharset()}, "Grüße"},
+		{"auto UTF-8", utf8Field, []PageFileOption{WithAutoCharset()}, "Grüße"},
+		{"forced", latin1, []PageFileOption{WithCharset("latin1")}, "Grüße"},
+		{"forced over auto", utf8Field, []PageFileOption{WithAutoCharset(), WithCharset("UTF-8")}, "Grüße"},
+		{"auto unsupported", koi8Field, []PageFileOption{WithAutoCharset()}, "\xf0\xd2\xc9\xd7\xc5\xd4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pf, err := ParsePageFile(strings.NewReader(test.input), test.opts...); err != nil {
+				t.Fatal(err)
+			} else if pf.Text != test.text {
+				t.Fatalf("%q != %q", pf.Text, test.text)
+			}
+		})
+	}
+}
+
+func TestPageFileCharsetInvalid(t *testing.T) {
+	input := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ncharset=KOI8-R\ntext=foo\n"
+	if _, err := ParsePageFile(strings.NewReader(input), WithCharset("KOI8-R")); err == nil {
+		t.Fatal("unsupported charset was accepted")
+	}
+
+	var out strings.Builder
+	if err := WritePageFile(&out, PageFile{Text: "€"}, WithCharset("ISO-8859-1")); err == nil {
+		t.Fatal("unencodable rune was accepted")
+	}
+}
+
+func TestCharsetSupported(t *testing.T) {
+	tests := []struct {
+		charset   string
+		supported bool
+	}{
+		{"UTF-8", true},
+		{"ISO-8859-1", true},
+		{"latin1", true},
+		{"windows-1252", false},
+		{"KOI8-R", false},
+	}
+
+	for _, test := range tests {
+		if supported := CharsetSupported(test.charset); supported != test.supported {
+			t.Fatalf("%s: expected %t, got %t", test.charset, test.supported, supported)
+		}
+	}
+}
+
+func TestPageFileCharsetAutoUnsupported(t *testing.T) {
+	input := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ncharset=windows-1252\nrev=1\ntext=caf\xe9%0a\ntime=10\n" +
+		"diff:10:10:=1d0%0a< caf\xe9%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input), WithAutoCharset())
+	if err != nil {
+		t.Fatal(err)
+	} else if pf.Charset != "windows-1252" || pf.Text != "caf\xe9\n" {
+		t.Fatalf("unexpected charset %q and text %q", pf.Charset, pf.Text)
+	}
+
+	var out strings.Builder
+	if err := WritePageFile(&out, pf, WithAutoCharset()); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(out.String(), "\ntext=caf\xe9%0a\n") {
+		t.Fatalf("text was converted:\n%s", out.String())
+	}
+}
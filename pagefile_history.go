@@ -0,0 +1,523 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"time"
+)
+
+// HistoryErrorKind classifies a HistoryError.
+type HistoryErrorKind int
+
+const (
+	_ HistoryErrorKind = iota
+
+	// HistoryCycle for a revision being part of a cycle of DiffAgainst references.
+	HistoryCycle
+	// HistoryOrphan for a revision which is unreachable from the page's current revision.
+	HistoryOrphan
+	// HistoryDangling for a DiffAgainst reference to a missing revision. This might also be the result of PmWiki
+	// expiring old revisions, as configured by $DiffKeepDays.
+	HistoryDangling
+	// HistoryDuplicate for multiple revisions being diffed against the same revision, resulting in a fork.
+	HistoryDuplicate
+)
+
+func (kind HistoryErrorKind) String() string {
+	switch kind {
+	case HistoryCycle:
+		return "cycle"
+	case HistoryOrphan:
+		return "orphan"
+	case HistoryDangling:
+		return "dangling reference"
+	case HistoryDuplicate:
+		return "duplicate target"
+	default:
+		return fmt.Sprintf("HistoryErrorKind(%d)", int(kind))
+	}
+}
+
+// HistoryError describes an inconsistency within a PageFile's revision chain, as reported by ValidateHistory.
+type HistoryError struct {
+	Kind HistoryErrorKind
+
+	// Time and DiffAgainst of the affected revision. A zero Time refers to the page's current time field.
+	Time        time.Time
+	DiffAgainst time.Time
+}
+
+func (err HistoryError) Error() string {
+	if err.Time == (time.Time{}) {
+		return fmt.Sprintf("%v: page's time references revision %v", err.Kind, err.DiffAgainst)
+	}
+	return fmt.Sprintf("%v: revision %v against %v", err.Kind, err.Time, err.DiffAgainst)
+}
+
+// diffTarget returns the index of the revision the i-th revision is diffed against or -1.
+//
+// The search starts after the i-th revision, as Revs are ordered from the newest to the oldest, but falls back to the
+// previous revisions to find invalid references as well.
+func (pageFile PageFile) diffTarget(i int) int {
+	diffAgainst := pageFile.Revs[i].DiffAgainst
+	if j := pageFile.revisionIndex(diffAgainst, i+1); j >= 0 {
+		return j
+	} else if j := pageFile.revisionIndex(diffAgainst, 0); j >= 0 && j < i {
+		return j
+	}
+	return -1
+}
+
+// ValidateHistory checks the DiffAgainst chain of this PageFile's Revs, which should start at the page's current
+// revision and end at its creation, without any forks.
+//
+// Revisions without a diff, e.g., a revision's author field without any diff field, are not part of the chain and
+// are therefore ignored.
+func (pageFile PageFile) ValidateHistory() (errs []HistoryError) {
+	if len(pageFile.Revs) == 0 {
+		return nil
+	}
+
+	newError := func(kind HistoryErrorKind, i int) HistoryError {
+		return HistoryError{Kind: kind, Time: pageFile.Revs[i].Time, DiffAgainst: pageFile.Revs[i].DiffAgainst}
+	}
+	isDiff := func(i int) bool {
+		return pageFile.Revs[i].DiffAgainst != (time.Time{})
+	}
+
+	targets := make([]int, len(pageFile.Revs))
+	referenced := make(map[int]bool)
+	for i, rev := range pageFile.Revs {
+		targets[i] = -1
+		if !isDiff(i) {
+			continue
+		}
+
+		targets[i] = pageFile.diffTarget(i)
+		if targets[i] < 0 {
+			// The page's creation is diffed against itself.
+			if !rev.DiffAgainst.Equal(rev.Time) {
+				errs = append(errs, newError(HistoryDangling, i))
+			}
+		} else if referenced[targets[i]] {
+			errs = append(errs, newError(HistoryDuplicate, i))
+		} else {
+			referenced[targets[i]] = true
+		}
+	}
+
+	// Each revision is visited once; a cycle is found when a walk reaches a revision visited within this walk.
+	const (
+		unvisited = iota
+		walking
+		visited
+	)
+	state := make([]int, len(pageFile.Revs))
+	for start := range pageFile.Revs {
+		if !isDiff(start) || state[start] != unvisited {
+			continue
+		}
+
+		var walk []int
+		i := start
+		for ; i >= 0 && state[i] == unvisited; i = targets[i] {
+			state[i] = walking
+			walk = append(walk, i)
+		}
+		if i >= 0 && state[i] == walking {
+			errs = append(errs, newError(HistoryCycle, i))
+		}
+		for _, j := range walk {
+			state[j] = visited
+		}
+	}
+
+	reachable := make([]bool, len(pageFile.Revs))
+	if head := pageFile.revisionIndex(pageFile.Time, 0); head < 0 {
+		errs = append(errs, HistoryError{Kind: HistoryDangling, DiffAgainst: pageFile.Time})
+	} else {
+		for i := head; i >= 0 && !reachable[i]; i = targets[i] {
+			reachable[i] = true
+		}
+	}
+	for i := range pageFile.Revs {
+		if isDiff(i) && !reachable[i] {
+			errs = append(errs, newError(HistoryOrphan, i))
+		}
+	}
+
+	return
+}
+
+// RevisionsLenient calls a function with a "view" copy of each revision of this PageFile, like Revisions, but keeps as
+// much history as possible for a broken DiffAgainst chain.
+//
+// First, the chain is followed backwards from the current revision until it breaks, e.g., due to a missing revision
+// or a failing Patch. Afterwards, the chain is restarted from the page's creation and followed forwards by applying
+// the inverted diffs. Revisions whose text cannot be reconstructed either way are passed as views with the Gap flag
+// and an empty Text. Missing revisions, referenced by a DiffAgainst, are passed as such Gap views without metadata.
+//
+// The views are passed from the newest to the oldest revision. The returned error reports the first inconsistency,
+// even though all views were passed.
+func (pageFile PageFile) RevisionsLenient(callback func(view PageFile)) (err error) {
+	if err := pageFile.checkContent(); err != nil {
+		return err
+	}
+
+	if pageFile.Deleted != (time.Time{}) {
+		defer callback(pageFile.deletedView())
+	}
+
+	if len(pageFile.Revs) == 0 {
+		callback(pageFile)
+		return nil
+	}
+
+	fail := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+
+	for _, histErr := range pageFile.ValidateHistory() {
+		if histErr.Kind != HistoryDangling {
+			fail(histErr)
+			break
+		}
+	}
+
+	isDiff := func(i int) bool {
+		return pageFile.Revs[i].DiffAgainst != (time.Time{})
+	}
+	isCreation := func(i int) bool {
+		return pageFile.Revs[i].DiffAgainst.Equal(pageFile.Revs[i].Time)
+	}
+
+	targets := make([]int, len(pageFile.Revs))
+	for i := range pageFile.Revs {
+		targets[i] = pageFile.diffTarget(i)
+	}
+
+	// chained marks the revisions on the chain from the current revision, as walked by Revisions. Those are passed,
+	// even without a diff, e.g., the creation of a page whose empty diff against itself was ignored.
+	chained := make(map[int]bool)
+	head := pageFile.revisionIndex(pageFile.Time, 0)
+	for i := head; i >= 0 && !chained[i]; i = targets[i] {
+		chained[i] = true
+	}
+
+	// texts of the reconstructed revisions by their index. created marks revisions diffed against a missing revision,
+	// resulting in an empty text. This is the creation of older page files.
+	texts := make(map[int]string)
+	created := make(map[int]bool)
+
+	if head < 0 {
+		fail(fmt.Errorf("revision %v is missing", pageFile.Time))
+	}
+
+	text := pageFile.Text
+	for i := head; i >= 0; i = targets[i] {
+		if _, ok := texts[i]; ok {
+			break
+		}
+		texts[i] = text
+
+		var applyErr error
+		if text, applyErr = pageFile.Revs[i].applyDiff(text); applyErr != nil {
+			fail(applyErr)
+			break
+		}
+
+		if targets[i] < 0 && !isCreation(i) {
+			if text == "" {
+				created[i] = true
+			} else {
+				fail(fmt.Errorf("revision %v is missing", pageFile.Revs[i].DiffAgainst))
+			}
+		}
+	}
+
+	// referrers are the indices of the diffs against each revision, ordered from the newest to the oldest one.
+	referrers := make(map[int][]int)
+	for j := range pageFile.Revs {
+		if isDiff(j) && targets[j] >= 0 {
+			referrers[targets[j]] = append(referrers[targets[j]], j)
+		}
+	}
+
+	for start := range pageFile.Revs {
+		if _, ok := texts[start]; ok || !isDiff(start) || !isCreation(start) || targets[start] >= 0 {
+			continue
+		}
+
+		text, applyErr := pageFile.Revs[start].applyInvertedDiff("")
+		for i := start; applyErr == nil; {
+			texts[i] = text
+
+			next := -1
+			for _, j := range referrers[i] {
+				if _, ok := texts[j]; !ok {
+					next = j
+					break
+				}
+			}
+			if next < 0 {
+				break
+			}
+
+			i = next
+			text, applyErr = pageFile.Revs[i].applyInvertedDiff(text)
+		}
+		if applyErr != nil {
+			fail(applyErr)
+		}
+	}
+
+	revNo := pageFile.Rev
+	if head < 0 {
+		current := PageFileRevision{
+			Time:    pageFile.Time,
+			Author:  pageFile.Author,
+			Host:    pageFile.Host,
+			Summary: pageFile.Summary,
+		}
+		callback(pageFile.revisionView(current, pageFile.Text, revNo))
+		revNo--
+	}
+
+	for i, rev := range pageFile.Revs {
+		if !isDiff(i) && !chained[i] {
+			continue
+		}
+
+		text, ok := texts[i]
+		view := pageFile.revisionView(rev, text, revNo)
+		view.Gap = !ok
+		callback(view)
+		revNo--
+
+		if targets[i] < 0 && isDiff(i) && !isCreation(i) && !created[i] {
+			callback(PageFile{
+				Version: pageFile.Version,
+				Name:    pageFile.Name,
+				Time:    rev.DiffAgainst,
+				Rev:     revNo,
+				Gap:     true,
+			})
+			revNo--
+		}
+	}
+
+	return
+}
+
+// revisionChain returns the indices of the revisions from the current revision following the DiffAgainst chain.
+//
+// An error is returned for an invalid history, as reported by ValidateHistory. However, an expired older history is
+// not reported.
+func (pageFile PageFile) revisionChain() (chain []int, err error) {
+	if len(pageFile.Revs) == 0 {
+		return nil, nil
+	}
+
+	for _, err := range pageFile.ValidateHistory() {
+		if err.Kind != HistoryDangling {
+			return nil, err
+		}
+	}
+
+	i := pageFile.revisionIndex(pageFile.Time, 0)
+	if i < 0 {
+		return nil, fmt.Errorf("revision %d is missing", pageFile.Rev)
+	}
+	for ; i >= 0; i = pageFile.diffTarget(i) {
+		chain = append(chain, i)
+	}
+	return
+}
+
+// PageFileHistory is a cursor over the views of a PageFile's revisions, as passed by Revisions, created by History.
+//
+// Moving the cursor to an older revision applies its stored reverse diff. As inverting a diff is lossy, newer texts
+// are reconstructed from the next newer checkpoint, resulting in the same texts as Revisions. These checkpoints are the
+// texts of every pageFileTextCacheInterval-th revision, kept after the first call of Next.
+type PageFileHistory struct {
+	pageFile PageFile
+
+	// chain of revision indices from the oldest to the newest revision.
+	chain []int
+
+	// pos of the cursor; -1 is before the oldest view and size() is after the newest view.
+	pos int
+	// text of the revision at chain[min(pos, len(chain)-1)].
+	text string
+
+	// checkpoints are texts by their chain position, including the newest revision's text. texts are the texts from a
+	// position up to the next newer checkpoint, reconstructed together for the following calls of Next.
+	checkpoints map[int]string
+	texts       map[int]string
+
+	err error
+}
+
+// History creates a PageFileHistory of this PageFile, positioned before its oldest revision.
+//
+// The cursor can be moved in chronological order by Next and in reverse by Prev, after calling SeekEnd. An invalid
+// history, as reported by ValidateHistory, results in an error from Err. Unlike Revisions, an expired older history
+// is not reported.
+func (pageFile PageFile) History() *PageFileHistory {
+	history := &PageFileHistory{pageFile: pageFile, pos: -1, text: pageFile.Text}
+
+	if err := pageFile.checkContent(); err != nil {
+		history.err = err
+		return history
+	}
+
+	chain, err := pageFile.revisionChain()
+	if err != nil {
+		history.err = err
+		return history
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		history.chain = append(history.chain, chain[i])
+	}
+	return history
+}
+
+// size is the amount of views, including the page itself without any revisions or a deleted page.
+func (history *PageFileHistory) size() int {
+	size := len(history.chain)
+	if len(history.pageFile.Revs) == 0 {
+		size++
+	}
+	if history.pageFile.Deleted != (time.Time{}) {
+		size++
+	}
+	return size
+}
+
+// rev returns the revision of the chain's i-th element.
+func (history *PageFileHistory) rev(i int) PageFileRevision {
+	return history.pageFile.Revs[history.chain[i]]
+}
+
+// checkpointTexts reconstructs the checkpoints by following the chain from the newest to the oldest revision.
+func (history *PageFileHistory) checkpointTexts() (map[int]string, error) {
+	last := len(history.chain) - 1
+	checkpoints := map[int]string{last: history.pageFile.Text}
+
+	text := history.pageFile.Text
+	for i := last; i > 0; i-- {
+		var err error
+		if text, err = history.rev(i).applyDiff(text); err != nil {
+			return nil, err
+		}
+		if (i-1)%pageFileTextCacheInterval == 0 {
+			checkpoints[i-1] = text
+		}
+	}
+	return checkpoints, nil
+}
+
+// textAt reconstructs the text at a chain position from the next newer checkpoint, as Revisions would do.
+func (history *PageFileHistory) textAt(pos int) (string, error) {
+	if text, ok := history.texts[pos]; ok {
+		return text, nil
+	}
+
+	if history.checkpoints == nil {
+		checkpoints, err := history.checkpointTexts()
+		if err != nil {
+			return "", err
+		}
+		history.checkpoints = checkpoints
+	}
+
+	start := pos + (pageFileTextCacheInterval-pos%pageFileTextCacheInterval)%pageFileTextCacheInterval
+	if last := len(history.chain) - 1; start > last {
+		start = last
+	}
+
+	text := history.checkpoints[start]
+	history.texts = map[int]string{start: text}
+	for i := start; i > pos; i-- {
+		var err error
+		if text, err = history.rev(i).applyDiff(text); err != nil {
+			return "", err
+		}
+		history.texts[i-1] = text
+	}
+	return text, nil
+}
+
+// Next moves the cursor to the next newer revision. It returns false if there is no such revision or an error
+// occurred, which is reported by Err.
+func (history *PageFileHistory) Next() bool {
+	if history.err != nil || history.pos >= history.size()-1 {
+		history.pos = history.size()
+		return false
+	}
+
+	history.pos++
+	if history.pos < len(history.chain) {
+		history.text, history.err = history.textAt(history.pos)
+	}
+
+	return history.err == nil
+}
+
+// Prev moves the cursor to the previous older revision. It returns false if there is no such revision or an error
+// occurred, which is reported by Err.
+func (history *PageFileHistory) Prev() bool {
+	if history.err != nil || history.pos <= 0 {
+		history.pos = -1
+		return false
+	}
+
+	if history.pos < len(history.chain) {
+		history.text, history.err = history.rev(history.pos).applyDiff(history.text)
+	}
+	history.pos--
+
+	return history.err == nil
+}
+
+// SeekStart positions the cursor before the oldest revision, to be iterated by Next.
+func (history *PageFileHistory) SeekStart() {
+	history.pos = -1
+}
+
+// SeekEnd positions the cursor after the newest revision, to be iterated by Prev.
+func (history *PageFileHistory) SeekEnd() {
+	if history.err == nil {
+		history.pos = history.size()
+		history.text = history.pageFile.Text
+	}
+}
+
+// View of the current revision, which is empty if the cursor is not positioned at a revision.
+func (history *PageFileHistory) View() PageFile {
+	switch {
+	case history.err != nil || history.pos < 0 || history.pos >= history.size():
+		return PageFile{}
+
+	case history.pos < len(history.chain):
+		revNo := history.pageFile.Rev - (len(history.chain) - 1 - history.pos)
+		return history.pageFile.revisionView(history.rev(history.pos), history.text, revNo)
+
+	case len(history.pageFile.Revs) == 0 && history.pos == 0:
+		return history.pageFile
+
+	default:
+		return history.pageFile.deletedView()
+	}
+}
+
+// Err returns the error which stopped the cursor, if any.
+func (history *PageFileHistory) Err() error {
+	return history.err
+}
@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageFileValidateHistory(t *testing.T) {
+	rev := func(t, diffAgainst int64) PageFileRevision {
+		return PageFileRevision{Time: time.Unix(t, 0).UTC(), DiffAgainst: time.Unix(diffAgainst, 0).UTC()}
+	}
+	histErr := func(kind HistoryErrorKind, t, diffAgainst int64) HistoryError {
+		return HistoryError{Kind: kind, Time: time.Unix(t, 0).UTC(), DiffAgainst: time.Unix(diffAgainst, 0).UTC()}
+	}
+
+	tests := []struct {
+		name string
+		time int64
+		revs []PageFileRevision
+		errs []HistoryError
+	}{
+		{"valid", 30, []PageFileRevision{rev(30, 20), rev(20, 10), rev(10, 10)}, nil},
+		{"same second", 20, []PageFileRevision{rev(20, 20), rev(20, 10), rev(10, 10)}, nil},
+		{"expired", 30, []PageFileRevision{rev(30, 20), rev(20, 10)},
+			[]HistoryError{histErr(HistoryDangling, 20, 10)}},
+		{"no diff", 30, []PageFileRevision{rev(30, 20), rev(20, 20), {Time: time.Unix(10, 0).UTC()}}, nil},
+		{"cycle", 30, []PageFileRevision{rev(30, 20), rev(20, 10), rev(10, 30)},
+			[]HistoryError{histErr(HistoryCycle, 30, 20)}},
+		{"fork", 40, []PageFileRevision{rev(40, 20), rev(30, 20), rev(20, 20)},
+			[]HistoryError{histErr(HistoryDuplicate, 30, 20), histErr(HistoryOrphan, 30, 20)}},
+		{"orphan", 30, []PageFileRevision{rev(30, 30), rev(20, 10), rev(10, 10)},
+			[]HistoryError{histErr(HistoryOrphan, 20, 10), histErr(HistoryOrphan, 10, 10)}},
+		{"missing head", 40, []PageFileRevision{rev(30, 30)},
+			[]HistoryError{{Kind: HistoryDangling, DiffAgainst: time.Unix(40, 0).UTC()}, histErr(HistoryOrphan, 30, 30)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pf := PageFile{Time: time.Unix(test.time, 0).UTC(), Revs: test.revs}
+			if errs := pf.ValidateHistory(); !reflect.DeepEqual(errs, test.errs) {
+				t.Fatalf("expected %v, got %v", test.errs, errs)
+			}
+		})
+	}
+}
+
+func TestPageFileRevisionsCycle(t *testing.T) {
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nname=Main.Test\nrev=2\ntext=a\ntime=20\n" +
+		"diff:20:10:=\ndiff:10:20:=\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var views int
+	err = pf.Revisions(func(PageFile) { views++ })
+
+	var histErr HistoryError
+	if !errors.As(err, &histErr) || histErr.Kind != HistoryCycle {
+		t.Fatalf("expected cycle error, got %v", err)
+	} else if views != 0 {
+		t.Fatalf("expected no views, got %d", views)
+	}
+}
+
+func TestPageFileRevisionsLenient(t *testing.T) {
+	newPageFile := func() PageFile {
+		return testPageFile(t, "a", "b", "c", "d")
+	}
+	corrupt := func(pf *PageFile, at int64) {
+		for i := range pf.Revs {
+			if pf.Revs[i].Time.Equal(time.Unix(at, 0)) {
+				pf.Revs[i].Diff = Diff("x", "")
+			}
+		}
+	}
+	remove := func(pf *PageFile, at int64) {
+		for i := range pf.Revs {
+			if pf.Revs[i].Time.Equal(time.Unix(at, 0)) {
+				pf.Revs = append(pf.Revs[:i], pf.Revs[i+1:]...)
+				return
+			}
+		}
+	}
+
+	type view struct {
+		time int64
+		text string
+		gap  bool
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*PageFile)
+		views  []view
+		err    bool
+	}{
+		{"valid", func(*PageFile) {}, []view{{40, "d", false}, {30, "c", false}, {20, "b", false}, {10, "a", false}}, false},
+		{"failing patch", func(pf *PageFile) { corrupt(pf, 30) },
+			[]view{{40, "d", false}, {30, "c", false}, {20, "b", false}, {10, "a", false}}, true},
+		{"missing revision", func(pf *PageFile) { remove(pf, 20) },
+			[]view{{40, "d", false}, {30, "c", false}, {20, "", true}, {10, "a", false}}, true},
+		{"missing creation", func(pf *PageFile) { corrupt(pf, 30); remove(pf, 10) },
+			[]view{{40, "d", false}, {30, "c", false}, {20, "", true}, {10, "", true}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pf := newPageFile()
+			test.modify(&pf)
+
+			var views []view
+			err := pf.RevisionsLenient(func(v PageFile) {
+				views = append(views, view{v.Time.Unix(), v.Text, v.Gap})
+			})
+
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error state, %v", err)
+			} else if !reflect.DeepEqual(views, test.views) {
+				t.Fatalf("expected %v, got %v", test.views, views)
+			}
+		})
+	}
+}
+
+func TestPageFileRevisionsLenientEmptyCreation(t *testing.T) {
+	// The creation's empty diff against itself is ignored, but its revision is still part of the chain.
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nrev=2\ntext=b\ntime=20\n" +
+		"author:20=user\ndiff:20:10:=1d0%0a%3c b%0a\n" +
+		"author:10=user\ndiff:10:10:=\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strict, lenient []PageFile
+	if err := pf.Revisions(func(view PageFile) { strict = append(strict, view) }); err != nil {
+		t.Fatal(err)
+	} else if err := pf.RevisionsLenient(func(view PageFile) { lenient = append(lenient, view) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(strict) != 2 {
+		t.Fatalf("expected two revisions, got %d", len(strict))
+	} else if !reflect.DeepEqual(strict, lenient) {
+		t.Fatalf("expected %v, got %v", strict, lenient)
+	}
+}
+
+func TestPageFileHistory(t *testing.T) {
+	texts := []string{"a", "a\nb", "", "b\nc\n"}
+
+	created := testPageFile(t, texts...)
+
+	expired := created
+	expired.Revs = expired.Revs[:len(expired.Revs)-1]
+
+	deleted := created
+	deleted.Deleted = time.Unix(50, 0).UTC()
+
+	long := testPageFileLines(t, 100)
+
+	// The diff of revision 2 deletes "a" with a trailing whitespace, which is accepted while being applied. Thus, the
+	// inverted diff would result in another text than Revisions.
+	trimmed, err := ParsePageFile(strings.NewReader("version=pmwiki-2.2.130 ordered=1 urlencoded=1\nrev=3\n" +
+		"text=x%0a\ntime=30\ndiff:30:20:=1c1%0a%3c x%0a---%0a> a%0a\n" +
+		"diff:20:10:=1c1%0a%3c a %0a---%0a> b%0a\ndiff:10:10:=1d0%0a%3c b%0a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		pageFile PageFile
+		missing  bool
+	}{
+		{"created", created, false},
+		{"expired", expired, true},
+		{"deleted", deleted, false},
+		{"no revisions", PageFile{Name: "Main.Test", Text: "foo", Time: time.Unix(10, 0).UTC()}, false},
+		{"long", long, false},
+		{"trimmed diff", trimmed, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var views []PageFile
+			if err := test.pageFile.Revisions(func(view PageFile) { views = append(views, view) }); (err != nil) != test.missing {
+				t.Fatal(err)
+			}
+			if test.pageFile.Deleted != (time.Time{}) {
+				// Revisions passes the deletion last, while it is the newest view.
+				views = append(views[len(views)-1:], views[:len(views)-1]...)
+			}
+
+			history := test.pageFile.History()
+
+			var forward []PageFile
+			for history.Next() {
+				forward = append([]PageFile{history.View()}, forward...)
+			}
+			if err := history.Err(); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(forward, views) {
+				t.Fatalf("forward: expected %v, got %v", views, forward)
+			}
+
+			history.SeekEnd()
+
+			var backward []PageFile
+			for history.Prev() {
+				backward = append(backward, history.View())
+			}
+			if err := history.Err(); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(backward, views) {
+				t.Fatalf("backward: expected %v, got %v", views, backward)
+			}
+		})
+	}
+}
+
+func TestPageFileHistoryDirections(t *testing.T) {
+	pf := testPageFile(t, "a", "b", "c")
+
+	history := pf.History()
+	if view := history.View(); view.Text != "" || view.Rev != 0 {
+		t.Fatalf("unexpected view before start %v", view)
+	}
+
+	steps := []struct {
+		next bool
+		ok   bool
+		text string
+	}{
+		{true, true, "a"}, {true, true, "b"}, {false, true, "a"}, {false, false, ""},
+		{true, true, "a"}, {true, true, "b"}, {true, true, "c"}, {true, false, ""}, {false, true, "c"},
+	}
+	for i, step := range steps {
+		ok := history.Prev
+		if step.next {
+			ok = history.Next
+		}
+
+		if ok() != step.ok {
+			t.Fatalf("step %d: expected %t", i, step.ok)
+		} else if text := history.View().Text; text != step.text {
+			t.Fatalf("step %d: expected %q, got %q", i, step.text, text)
+		}
+	}
+}
+
+func TestPageFileHistoryCycle(t *testing.T) {
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nname=Main.Test\nrev=2\ntext=a\ntime=20\n" +
+		"diff:20:10:=\ndiff:10:20:=\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := pf.History()
+	if history.Next() {
+		t.Fatal("cyclic history was iterated")
+	} else if history.Err() == nil {
+		t.Fatal("cyclic history has no error")
+	}
+}
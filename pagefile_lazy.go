@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// pageFileLazyDiff is a revision's raw diff, whose parsing was deferred by WithLazyDiffs.
+type pageFileLazyDiff struct {
+	// raw value of the diff field and the page file's settings to decode and parse it.
+	raw        string
+	newline    string
+	urlencoded bool
+	strict     bool
+
+	// conv is an optional charsetConverter, applied to the parsed Patch.
+	conv charsetConverter
+
+	// patch and err are the cached result after the first parse, guarded by once for concurrent readers.
+	once  sync.Once
+	patch Patch
+	err   error
+}
+
+// parse the raw diff into a Patch, which is cached afterwards. It is safe for concurrent use.
+func (lazyDiff *pageFileLazyDiff) parse() (Patch, error) {
+	lazyDiff.once.Do(func() {
+		reader := &pageFileValueReader{value: lazyDiff.raw, newline: lazyDiff.newline, urlencoded: lazyDiff.urlencoded}
+		patch, err := parsePatchReader(reader, lazyDiff.strict)
+		if err == nil && lazyDiff.conv != nil {
+			patch, err = patch.convert(lazyDiff.conv)
+		}
+
+		lazyDiff.patch, lazyDiff.err = patch, err
+	})
+	return lazyDiff.patch, lazyDiff.err
+}
+
+// decode the raw diff into its textual representation, without parsing it.
+func (lazyDiff *pageFileLazyDiff) decode() (string, error) {
+	reader := &pageFileValueReader{value: lazyDiff.raw, newline: lazyDiff.newline, urlencoded: lazyDiff.urlencoded}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if lazyDiff.conv != nil {
+		return lazyDiff.conv(string(data))
+	}
+	return string(data), nil
+}
+
+// converted returns a copy of this pageFileLazyDiff, which additionally applies a charsetConverter after parsing.
+func (lazyDiff *pageFileLazyDiff) converted(conv charsetConverter) *pageFileLazyDiff {
+	if prevConv, nextConv := lazyDiff.conv, conv; prevConv != nil {
+		conv = func(s string) (string, error) {
+			if s, err := prevConv(s); err != nil {
+				return "", err
+			} else {
+				return nextConv(s)
+			}
+		}
+	}
+
+	return &pageFileLazyDiff{
+		raw:        lazyDiff.raw,
+		newline:    lazyDiff.newline,
+		urlencoded: lazyDiff.urlencoded,
+		strict:     lazyDiff.strict,
+		conv:       conv,
+	}
+}
+
+// Patch returns this revision's Diff. A diff deferred by WithLazyDiffs is parsed first, possibly resulting in an error.
+func (rev PageFileRevision) Patch() (Patch, error) {
+	if rev.lazyDiff == nil {
+		return rev.Diff, nil
+	}
+
+	patch, err := rev.lazyDiff.parse()
+	if err != nil {
+		return nil, fmt.Errorf("parsing diff of revision %v errored, %w", rev.Time, err)
+	}
+	return patch, nil
+}
+
+// RawDiff returns this revision's diff in PmWiki's textual format. A diff deferred by WithLazyDiffs is returned as it
+// is stored, being decoded but neither parsed nor validated. Otherwise, the Diff is formatted.
+func (rev PageFileRevision) RawDiff() (string, error) {
+	if rev.lazyDiff == nil {
+		return rev.Diff.String(), nil
+	}
+
+	diff, err := rev.lazyDiff.decode()
+	if err != nil {
+		return "", fmt.Errorf("decoding diff of revision %v errored, %w", rev.Time, err)
+	}
+	return diff, nil
+}
+
+// applyDiff applies this revision's reverse diff to the newer text, resulting in this revision's previous text.
+func (rev PageFileRevision) applyDiff(text string) (string, error) {
+	patch, err := rev.Patch()
+	if err != nil {
+		return "", err
+	}
+	return patch.applyString(text)
+}
+
+// applyInvertedDiff applies this revision's inverted diff to the older text, resulting in this revision's text.
+func (rev PageFileRevision) applyInvertedDiff(text string) (string, error) {
+	patch, err := rev.Patch()
+	if err != nil {
+		return "", err
+	}
+	return patch.Invert().applyString(text)
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParsePageFileLazyDiffs(t *testing.T) {
+	pf := testPageFileLines(t, 10)
+
+	var buf bytes.Buffer
+	if err := WritePageFile(&buf, pf); err != nil {
+		t.Fatal(err)
+	}
+	input := buf.String()
+
+	eager, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := ParsePageFile(strings.NewReader(input), WithLazyDiffs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, rev := range lazy.Revs {
+		if rev.Diff != nil {
+			t.Fatalf("revision %d was parsed eagerly", i)
+		} else if patch, err := rev.Patch(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(patch, eager.Revs[i].Diff) {
+			t.Fatalf("revision %d: %v differs from %v", i, patch, eager.Revs[i].Diff)
+		}
+
+		if raw, err := rev.RawDiff(); err != nil {
+			t.Fatal(err)
+		} else if eagerRaw, _ := eager.Revs[i].RawDiff(); raw != eagerRaw {
+			t.Fatalf("revision %d: raw diff %q differs from %q", i, raw, eagerRaw)
+		}
+	}
+
+	var eagerViews, lazyViews []string
+	if err := eager.Revisions(func(view PageFile) { eagerViews = append(eagerViews, view.Text) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := lazy.Revisions(func(view PageFile) { lazyViews = append(lazyViews, view.Text) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(eagerViews, lazyViews) {
+		t.Fatalf("texts %v differ from %v", lazyViews, eagerViews)
+	}
+
+	if text, err := lazy.TextAtRev(3); err != nil {
+		t.Fatal(err)
+	} else if text != "line 3\n" {
+		t.Fatalf("unexpected text %q", text)
+	}
+
+	buf.Reset()
+	if err := WritePageFile(&buf, lazy); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != input {
+		t.Fatalf("written page file differs:\n%s\n%s", buf.String(), input)
+	}
+}
+
+func TestParsePageFileLazyDiffsInvalid(t *testing.T) {
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nrev=3\ntext=c\ntime=30\n" +
+		"diff:30:20:=1c1%0a< c%0a---%0a> b%0a\n" +
+		"diff:20:10:=AAAAAAAAAA\n" +
+		"diff:10:10:=1d0%0a< a%0a\n"
+
+	if _, err := ParsePageFile(strings.NewReader(input)); err == nil {
+		t.Fatal("invalid diff was accepted")
+	}
+
+	pf, err := ParsePageFile(strings.NewReader(input), WithLazyDiffs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text, err := pf.TextAtRev(2); err != nil {
+		t.Fatal(err)
+	} else if text != "b\n" {
+		t.Fatalf("unexpected text %q", text)
+	}
+	if _, err := pf.TextAtRev(1); err == nil {
+		t.Fatal("text after an invalid diff was reconstructed")
+	}
+	if raw, err := pf.Revs[1].RawDiff(); err != nil || raw != "AAAAAAAAAA" {
+		t.Fatalf("raw diff of the invalid diff is %q, %v", raw, err)
+	}
+
+	var views int
+	if err := pf.Revisions(func(PageFile) { views++ }); err == nil {
+		t.Fatal("Revisions did not fail")
+	} else if views != 2 {
+		t.Fatalf("%d views were passed before the error", views)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePageFile(&buf, pf); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(buf.String(), "\ndiff:20:10:=AAAAAAAAAA\n") {
+		t.Fatalf("invalid diff was not written back as it was stored:\n%s", buf.String())
+	}
+}
+
+func TestParsePageFileLazyDiffsCharset(t *testing.T) {
+	input := "version=pmwiki-2.1.27 ordered=1 urlencoded=1\ncharset=ISO-8859-1\n" +
+		"name=Main.Test\nrev=1\ntext=Gr\xfc\xdfe\ntime=20\n" +
+		"diff:20:20:=1d0%0a%3c Gr\xfc\xdfe%0a\\ No newline at end of file%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input), WithAutoCharset(), WithLazyDiffs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if patch, err := pf.Revs[0].Patch(); err != nil {
+		t.Fatal(err)
+	} else if patch[0].deletionLines[0] != "Grüße" {
+		t.Fatalf("diff was not decoded, %q", patch[0].deletionLines[0])
+	}
+	if raw, err := pf.Revs[0].RawDiff(); err != nil {
+		t.Fatal(err)
+	} else if raw != "1d0\n< Grüße\n\\ No newline at end of file\n" {
+		t.Fatalf("raw diff was not decoded, %q", raw)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePageFile(&buf, pf, WithAutoCharset()); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(buf.String(), "%3c Gr\xfc\xdfe") {
+		t.Fatalf("diff was not encoded, %s", buf.String())
+	}
+}
+
+func TestParsePageFileLazyDiffsConcurrent(t *testing.T) {
+	pf := testPageFileLines(t, 10)
+
+	var buf bytes.Buffer
+	if err := WritePageFile(&buf, pf); err != nil {
+		t.Fatal(err)
+	}
+
+	lazy, err := ParsePageFile(&buf, WithLazyDiffs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- lazy.Revisions(func(PageFile) {})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
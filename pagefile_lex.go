@@ -28,8 +28,13 @@ const (
 	pageFileKeyOpt
 	// pageFileValue for the previous pageFileKey.
 	pageFileValue
+	// pageFileSkippedValue replaces a non-empty pageFileValue, which was dropped as its pageFileKey is skipped.
+	pageFileSkippedValue
 )
 
+// pageFileLexBufferSize is the size of the pageFileLexer's read buffer. Longer values are still supported.
+const pageFileLexBufferSize = 64 * 1024
+
 // pageFileLexItem is a tuple of a pageFileLexType with its value.
 type pageFileLexItem struct {
 	t pageFileLexType
@@ -39,10 +44,20 @@ type pageFileLexItem struct {
 // pageFileLexer is a lexer to tokenize PmWiki's PageFileFormat.
 //
 // Its logic and code structure is heavily inspired by Rob Pike's "Lexical Scanning in Go" talk,
-// <https://talks.golang.org/2011/lex.slide>. However, it operates on an io.Reader instead of a string.
+// <https://talks.golang.org/2011/lex.slide>. However, it operates on an io.Reader instead of a string. Furthermore, the
+// states are not run within their own goroutine, but are advanced on demand by nextItem. Thus, a consumer might stop
+// at any time without leaving a blocked goroutine behind.
 type pageFileLexer struct {
 	reader *bufio.Reader
-	items  chan pageFileLexItem
+	// buf is reused to assemble fields, which are not available as a slice of the reader's buffer.
+	buf []byte
+
+	// key is the last pageFileKey. Non-empty values of keys within skip are dropped and emitted as pageFileSkippedValue.
+	key  string
+	skip map[string]bool
+
+	state pageFileLexStateFunc
+	items []pageFileLexItem
 }
 
 // pageFileLexStateFunc is lexing a pageFileLexer and returns its successive pageFileLexStateFunc.
@@ -80,80 +95,111 @@ func init() {
 // pageFileLexKeyOrKeyOptGenerator generates pageFileLexKey and pageFileLexKeyOpt.
 func pageFileLexKeyOrKeyOptGenerator(t pageFileLexType) pageFileLexStateFunc {
 	return func(lexer *pageFileLexer) pageFileLexStateFunc {
-		var field string
+		lexer.buf = lexer.buf[:0]
 		for {
-			r, err := lexer.next()
+			b, err := lexer.next()
 			if err != nil {
 				return lexer.errorf("%v", err)
 			}
 
-			switch r {
-			case ':':
-				return lexer.emit(t, field, pageFileLexKeyOpt)
+			switch b {
+			case ':', '=':
+				field := string(lexer.buf)
+				if t == pageFileKey {
+					lexer.key = field
+				}
 
-			case '=':
+				if b == ':' {
+					return lexer.emit(t, field, pageFileLexKeyOpt)
+				}
 				return lexer.emit(t, field, pageFileLexVal)
 
 			default:
-				if unicode.IsSpace(r) {
+				if unicode.IsSpace(rune(b)) {
 					return lexer.errorf("unexpected white space")
 				}
-				field += string(r)
+				lexer.buf = append(lexer.buf, b)
 			}
 		}
 	}
 }
 
 // pageFileLexVal extracts a pageFileKey's pageFileValue.
+//
+// The value is sliced from the reader's buffer up to the next newline. Only values exceeding this buffer are
+// assembled within the pageFileLexer's own buffer.
 func pageFileLexVal(lexer *pageFileLexer) pageFileLexStateFunc {
-	var field string
+	skip, skipped := lexer.skip[lexer.key], false
+
+	lexer.buf = lexer.buf[:0]
 	for {
-		r, err := lexer.next()
-		if err != nil {
+		line, err := lexer.reader.ReadSlice('\n')
+		switch {
+		case err == bufio.ErrBufferFull:
+			if skip {
+				skipped = true
+			} else {
+				lexer.buf = append(lexer.buf, line...)
+			}
+
+		case err != nil:
 			return lexer.errorf("%v", err)
-		}
 
-		if r == '\n' {
-			return lexer.emit(pageFileValue, field, pageFileLexBegin)
+		case skip && (skipped || len(line) > 1):
+			return lexer.emit(pageFileSkippedValue, "", pageFileLexBegin)
+
+		case skip:
+			return lexer.emit(pageFileValue, "", pageFileLexBegin)
+
+		case len(lexer.buf) == 0:
+			return lexer.emit(pageFileValue, string(line[:len(line)-1]), pageFileLexBegin)
+
+		default:
+			lexer.buf = append(lexer.buf, line[:len(line)-1]...)
+			return lexer.emit(pageFileValue, string(lexer.buf), pageFileLexBegin)
 		}
-		field += string(r)
 	}
 }
 
-// lexPageFile starts a lexical analysis for PmWiki's PageFileFormat. The tokens are sent to the channel.
-func lexPageFile(reader io.Reader) <-chan pageFileLexItem {
-	lexer := &pageFileLexer{
-		reader: bufio.NewReader(reader),
-		items:  make(chan pageFileLexItem),
+// lexPageFile starts a lexical analysis for PmWiki's PageFileFormat. The tokens are requested by nextItem.
+func lexPageFile(reader io.Reader) *pageFileLexer {
+	return &pageFileLexer{
+		reader: bufio.NewReaderSize(reader, pageFileLexBufferSize),
+		state:  pageFileLexBegin,
 	}
-	go lexer.run()
-
-	return lexer.items
 }
 
-// run the pageFileLexer's states.
-func (lexer *pageFileLexer) run() {
-	for state := pageFileLexBegin; state != nil; state = state(lexer) {
+// nextItem runs the pageFileLexer's states until the next token was emitted. After the final pageFileEOF or
+// pageFileError, ok is false and only pageFileEOFs are returned.
+func (lexer *pageFileLexer) nextItem() (item pageFileLexItem, ok bool) {
+	for len(lexer.items) == 0 {
+		if lexer.state == nil {
+			return pageFileLexItem{pageFileEOF, ""}, false
+		}
+		lexer.state = lexer.state(lexer)
 	}
-	close(lexer.items)
+
+	item = lexer.items[0]
+	lexer.items = append(lexer.items[:0], lexer.items[1:]...)
+	return item, true
 }
 
-// next rune from the underlying buffer.
-func (lexer *pageFileLexer) next() (r rune, err error) {
-	r, _, err = lexer.reader.ReadRune()
-	return
+// next byte from the underlying buffer. Bytes are used instead of runes, because legacy page files are not
+// necessarily UTF-8 encoded.
+func (lexer *pageFileLexer) next() (b byte, err error) {
+	return lexer.reader.ReadByte()
 }
 
-// backup the last rune.
+// backup the last byte.
 func (lexer *pageFileLexer) backup() {
-	if err := lexer.reader.UnreadRune(); err != nil {
+	if err := lexer.reader.UnreadByte(); err != nil {
 		panic(err)
 	}
 }
 
 // emit a token back and return the successive state.
 func (lexer *pageFileLexer) emit(t pageFileLexType, v string, succ pageFileLexStateFunc) pageFileLexStateFunc {
-	lexer.items <- pageFileLexItem{t, v}
+	lexer.items = append(lexer.items, pageFileLexItem{t, v})
 	return succ
 }
 
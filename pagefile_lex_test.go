@@ -5,6 +5,7 @@
 package pmwiki
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -77,6 +78,15 @@ func TestLexPageFileValid(t *testing.T) {
 		{pageFileEOF, ""},
 	}
 
+	input7 := "version=pmwiki-1.0.13 newline=\262\ntext=foo\262bar\n"
+	items7 := []pageFileLexItem{
+		{pageFileKey, "version"},
+		{pageFileValue, "pmwiki-1.0.13 newline=\262"},
+		{pageFileKey, "text"},
+		{pageFileValue, "foo\262bar"},
+		{pageFileEOF, ""},
+	}
+
 	tests := []struct {
 		name  string
 		input string
@@ -88,12 +98,14 @@ func TestLexPageFileValid(t *testing.T) {
 		{"key options", input4, items4},
 		{"multiple key options", input5, items5},
 		{"empty diff", input6, items6},
+		{"non UTF-8 bytes", input7, items7},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			var items []pageFileLexItem
-			for item := range lexPageFile(strings.NewReader(test.input)) {
+			lexer := lexPageFile(strings.NewReader(test.input))
+			for item, ok := lexer.nextItem(); ok; item, ok = lexer.nextItem() {
 				items = append(items, item)
 			}
 
@@ -113,6 +125,42 @@ func TestLexPageFileValid(t *testing.T) {
 	}
 }
 
+func TestLexPageFileSkip(t *testing.T) {
+	input := "version=pmwiki-2.1.0 urlencoded=1\ntext=Markup text\ndiff:42:23:=1d0%0a< foo%0a\ndiff:23:23:=\n" +
+		"text:42=" + strings.Repeat("x", 2*pageFileLexBufferSize) + "\n"
+	expected := []pageFileLexItem{
+		{pageFileKey, "version"},
+		{pageFileValue, "pmwiki-2.1.0 urlencoded=1"},
+		{pageFileKey, "text"},
+		{pageFileSkippedValue, ""},
+		{pageFileKey, "diff"},
+		{pageFileKeyOpt, "42"},
+		{pageFileKeyOpt, "23"},
+		{pageFileKeyOpt, ""},
+		{pageFileSkippedValue, ""},
+		{pageFileKey, "diff"},
+		{pageFileKeyOpt, "23"},
+		{pageFileKeyOpt, "23"},
+		{pageFileKeyOpt, ""},
+		{pageFileValue, ""},
+		{pageFileKey, "text"},
+		{pageFileKeyOpt, "42"},
+		{pageFileSkippedValue, ""},
+		{pageFileEOF, ""},
+	}
+
+	var items []pageFileLexItem
+	lexer := lexPageFile(strings.NewReader(input))
+	lexer.skip = map[string]bool{"text": true, "diff": true}
+	for item, ok := lexer.nextItem(); ok; item, ok = lexer.nextItem() {
+		items = append(items, item)
+	}
+
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected %v, got %v", expected, items)
+	}
+}
+
 func TestLexPageFileInvalid(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -128,7 +176,8 @@ func TestLexPageFileInvalid(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			for item := range lexPageFile(strings.NewReader(test.input)) {
+			lexer := lexPageFile(strings.NewReader(test.input))
+			for item, ok := lexer.nextItem(); ok; item, ok = lexer.nextItem() {
 				if item.t == pageFileError {
 					return
 				}
@@ -137,3 +186,52 @@ func TestLexPageFileInvalid(t *testing.T) {
 		})
 	}
 }
+
+// syntheticPageFile creates a page file with a text of about textSize bytes and revs revisions, each adding a line.
+func syntheticPageFile(textSize, revs int) string {
+	var builder strings.Builder
+
+	builder.WriteString("version=pmwiki-2.2.130 ordered=1 urlencoded=1\n")
+	builder.WriteString("name=Main.Benchmark\ntext=")
+	for builder.Len() < textSize {
+		builder.WriteString("Lorem ipsum dolor sit amet, consectetur adipiscing elit.%0a")
+	}
+	builder.WriteString("\n")
+
+	for i := revs; i > 0; i-- {
+		fmt.Fprintf(&builder, "author:%d=user\n", 1600000000+i)
+		fmt.Fprintf(&builder, "diff:%d:%d:=%dd%d%%0a< Revision %d%%0a\n", 1600000000+i, 1600000000+i-1, i, i-1, i)
+	}
+
+	return builder.String()
+}
+
+func BenchmarkLexPageFile(b *testing.B) {
+	sizes := []struct {
+		textSize int
+		revs     int
+	}{
+		{1 << 10, 10},
+		{1 << 16, 100},
+		{1 << 20, 1000},
+		{1 << 23, 10000},
+	}
+
+	for _, size := range sizes {
+		input := syntheticPageFile(size.textSize, size.revs)
+
+		b.Run(fmt.Sprintf("text=%d,revs=%d", size.textSize, size.revs), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+
+			for i := 0; i < b.N; i++ {
+				lexer := lexPageFile(strings.NewReader(input))
+				for item, ok := lexer.nextItem(); ok; item, ok = lexer.nextItem() {
+					if item.t == pageFileError {
+						b.Fatal(item.v)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+// pageFileOptions are the settings altered by PageFileOptions.
+type pageFileOptions struct {
+	charset     string
+	autoCharset bool
+
+	strictPatches bool
+	headerOnly    bool
+	lazyDiffs     bool
+}
+
+// PageFileOption alters the behavior of ParsePageFile or WritePageFile.
+type PageFileOption func(*pageFileOptions)
+
+// newPageFileOptions applies all PageFileOptions.
+func newPageFileOptions(opts []PageFileOption) (options pageFileOptions) {
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return
+}
+
+// WithCharset forces the page file's charset, e.g., "ISO-8859-1", regardless of its charset field.
+//
+// ParsePageFile converts all values from this charset to UTF-8, WritePageFile converts them back. A PageFile converted
+// by ParsePageFile is converted back by WritePageFile even without this option, unless its charset field was changed.
+func WithCharset(charset string) PageFileOption {
+	return func(options *pageFileOptions) {
+		options.charset = charset
+	}
+}
+
+// WithAutoCharset uses the page file's charset field for conversions, as described for WithCharset.
+//
+// A page file with an unsupported charset, see CharsetSupported, is neither converted while being parsed nor while
+// being written. Its charset is still available as PageFile.Charset. A forced charset by WithCharset has precedence.
+func WithAutoCharset() PageFileOption {
+	return func(options *pageFileOptions) {
+		options.autoCharset = true
+	}
+}
+
+// WithStrictPatches validates each diff's declared line ranges against its lines while parsing, instead of only
+// considering their start lines. This affects ParsePageFile only.
+func WithStrictPatches() PageFileOption {
+	return func(options *pageFileOptions) {
+		options.strictPatches = true
+	}
+}
+
+// WithHeaderOnly skips the text and all diffs while parsing, e.g., to list many pages by their metadata. The fields
+// are still validated as usual, but their values are neither decoded nor parsed. Thus, the resulting PageFile has an
+// empty Text and its Revs have no Diffs. Revisions, History, TextAt, AddRevision, and WritePageFile return an error
+// for such a PageFile.
+//
+// This affects ParsePageFile only, but is already implied by ParsePageFileHeader.
+func WithHeaderOnly() PageFileOption {
+	return func(options *pageFileOptions) {
+		options.headerOnly = true
+	}
+}
+
+// WithLazyDiffs defers parsing each diff until it is needed, e.g., by Revisions or TextAt. Thus, parsing a page file
+// with a long history is faster if only its text or recent revisions are of interest. Errors of an invalid diff are
+// not reported by ParsePageFile, but by the method reaching this diff. A deferred diff is not available within its
+// revision's Diff field, but by its Patch method. WritePageFile writes a deferred diff back as it was stored, even an
+// invalid one.
+//
+// This affects ParsePageFile only.
+func WithLazyDiffs() PageFileOption {
+	return func(options *pageFileOptions) {
+		options.lazyDiffs = true
+	}
+}
+
+// pageFileCharset returns the charset to be used for a PageFile or an empty string, if no conversion is necessary.
+// Without any option, a PageFile converted by ParsePageFile is handled as for WithAutoCharset, falling back to its
+// original charset.
+func (options pageFileOptions) pageFileCharset(pageFile PageFile) string {
+	if options.charset != "" {
+		return options.charset
+	} else if (options.autoCharset || pageFile.decodedCharset != "") && CharsetSupported(pageFile.Charset) {
+		return pageFile.Charset
+	}
+	return pageFile.decodedCharset
+}
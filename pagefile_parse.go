@@ -5,6 +5,8 @@
 package pmwiki
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -14,14 +16,24 @@ import (
 	"time"
 )
 
-// pageFileParser parses a PageFile based on the pageFileLexer's channel.
+// pageFileParser parses a PageFile based on the pageFileLexer's tokens.
 type pageFileParser struct {
 	pf  PageFile
 	err error
 
 	urlencoded bool
+	newline    string
 
-	lexItems <-chan pageFileLexItem
+	options pageFileOptions
+
+	// revFields are the author, host, and csum fields of revisions, which are shared by all diffs within this second.
+	revFields map[time.Time]*PageFileRevision
+	// diffs are the Time and DiffAgainst pairs of all diffs as Unix timestamps, to detect duplicates.
+	diffs map[[2]int64]bool
+	// emptyDiffs are the indices of empty diffs against themselves within the Unknown fields, see finishRevs.
+	emptyDiffs []int
+
+	lexer *pageFileLexer
 }
 
 // pageFileParseStateFunc parses a pageFileLexer and returns its successive pageFileParseStateFunc.
@@ -29,7 +41,8 @@ type pageFileParseStateFunc func(*pageFileParser) pageFileParseStateFunc
 
 // next item from the lexer.
 func (parser *pageFileParser) next() pageFileLexItem {
-	return <-parser.lexItems
+	item, _ := parser.lexer.nextItem()
+	return item
 }
 
 // nextType returns the next matching item's value. A positive max value restricts the amount skipped items.
@@ -40,7 +53,7 @@ func (parser *pageFileParser) nextType(lexType pageFileLexType, max int) (v stri
 		} else if item.t == pageFileEOF {
 			return "", io.EOF
 		} else if item.t == pageFileError {
-			return "", fmt.Errorf(item.v)
+			return "", errors.New(item.v)
 		}
 	}
 	return "", fmt.Errorf("no item with type %v found in %d messages", lexType, max)
@@ -66,6 +79,11 @@ func (parser *pageFileParser) errorf(format string, args ...interface{}) pageFil
 	return nil
 }
 
+// unknown stores an unsupported field to be kept within the PageFile.
+func (parser *pageFileParser) unknown(key, value string, opts []string) {
+	parser.pf.Unknown = append(parser.pf.Unknown, PageFileField{Key: key, Opts: opts, Value: value})
+}
+
 // pageFileParseVersion parses the initial version item.
 func pageFileParseVersion(parser *pageFileParser) pageFileParseStateFunc {
 	if err := parser.acceptItem(pageFileLexItem{pageFileKey, "version"}); err != nil {
@@ -75,8 +93,9 @@ func pageFileParseVersion(parser *pageFileParser) pageFileParseStateFunc {
 	if version, err := parser.nextType(pageFileValue, 1); err != nil {
 		return parser.errorf("initial version expected, %w", err)
 	} else {
-		parser.urlencoded = strings.Contains(version, "urlencoded=1")
 		parser.pf.Version = version
+		parser.urlencoded = parser.pf.VersionInfo().URLEncoded()
+		parser.newline = parser.pf.VersionInfo().Newline()
 	}
 
 	return pageFileParseFields
@@ -87,13 +106,25 @@ func pageFileParseFields(parser *pageFileParser) pageFileParseStateFunc {
 	for {
 		key, err := parser.nextType(pageFileKey, 0)
 		if err == io.EOF {
+			parser.finishRevs()
 			return nil
 		} else if err != nil {
 			return parser.errorf("parsing key errored, %w", err)
 		}
 
 		var opts []string
-		var value string
+		var raw string
+		var skipped bool
+
+		if key == "newline" {
+			// Legacy page files might define their newline encoding in a field instead of the version's attribute.
+			if newline, err := parser.nextType(pageFileValue, 1); err != nil {
+				return parser.errorf("parsing newline errored, %w", err)
+			} else {
+				parser.newline = newline
+				continue
+			}
+		}
 
 	itemTokenLoop:
 		for item := parser.next(); ; item = parser.next() {
@@ -101,15 +132,8 @@ func pageFileParseFields(parser *pageFileParser) pageFileParseStateFunc {
 			case pageFileKeyOpt:
 				opts = append(opts, item.v)
 
-			case pageFileValue:
-				if parser.urlencoded {
-					if value, err = url.QueryUnescape(strings.ReplaceAll(item.v, "+", "%2b")); err != nil {
-						return parser.errorf("URL decoding value errored, %w", err)
-					}
-				} else {
-					value = item.v
-				}
-
+			case pageFileValue, pageFileSkippedValue:
+				raw, skipped = item.v, item.t == pageFileSkippedValue
 				break itemTokenLoop
 
 			default:
@@ -117,7 +141,12 @@ func pageFileParseFields(parser *pageFileParser) pageFileParseStateFunc {
 			}
 		}
 
-		if len(opts) == 0 {
+		if key == "diff" && len(opts) > 0 {
+			// Diffs are decoded while being parsed, without an intermediate copy.
+			err = pageFileParseDiff(parser, raw, skipped, opts)
+		} else if value, decodeErr := parser.decode(raw); decodeErr != nil {
+			return parser.errorf("URL decoding value errored, %w", decodeErr)
+		} else if len(opts) == 0 {
 			err = pageFileParseMainItem(parser, key, value)
 		} else {
 			err = pageFileParseRev(parser, key, value, opts)
@@ -148,10 +177,11 @@ func pageFileParseMainItem(parser *pageFileParser, key, value string) error {
 		}
 
 	case "text":
-		if parser.pf.Text != "" {
+		if parser.pf.present[key] {
 			return fmt.Errorf("text field was already set")
 		}
 		parser.pf.Text = value
+		parser.pf.present[key] = true
 
 	case "author":
 		if parser.pf.Author != "" {
@@ -179,8 +209,36 @@ func pageFileParseMainItem(parser *pageFileParser, key, value string) error {
 			parser.pf.Rev = int(rev)
 		}
 
+	case "ctime":
+		if parser.pf.CreateTime != (time.Time{}) {
+			return fmt.Errorf("ctime field was already set")
+		}
+		if unix, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("ctime parsing errored, %w", err)
+		} else {
+			parser.pf.CreateTime = time.Unix(unix, 0).UTC()
+		}
+
+	case "targets":
+		if parser.pf.present[key] {
+			return fmt.Errorf("targets field was already set")
+		}
+		if value != "" {
+			parser.pf.Targets = strings.Split(value, ",")
+		}
+		parser.pf.present[key] = true
+
 	default:
-		// unknown / unsupported item
+		if field := parser.pf.stringField(key); field != nil {
+			if parser.pf.present[key] {
+				return fmt.Errorf("%s field was already set", key)
+			}
+			*field = value
+			parser.pf.present[key] = true
+		} else {
+			// unknown / unsupported item
+			parser.unknown(key, value, nil)
+		}
 	}
 
 	return nil
@@ -191,21 +249,22 @@ func pageFileParseRev(parser *pageFileParser, key, value string, opts []string)
 	// Items in our interest are starting with the time as the first pageFileKeyOpt. Other items will be ignored.
 	unixInt, unixErr := strconv.ParseInt(opts[0], 10, 64)
 	if unixErr != nil {
+		parser.unknown(key, value, opts)
 		return nil
 	}
 
 	unix := time.Unix(unixInt, 0).UTC()
-	pfr := parser.pf.Revs[unix]
-	pfr.Time = unix
 
 	switch key {
 	case "author":
+		pfr := parser.revField(unix)
 		if pfr.Author != "" {
 			return fmt.Errorf("author field was already set")
 		}
 		pfr.Author = value
 
 	case "host":
+		pfr := parser.revField(unix)
 		if len(pfr.Host) != 0 {
 			return fmt.Errorf("host field was already set")
 		}
@@ -215,50 +274,255 @@ func pageFileParseRev(parser *pageFileParser, key, value string, opts []string)
 			pfr.Host = host
 		}
 
-	case "diff":
-		if len(opts) < 2 {
-			return fmt.Errorf("diff requires at least two keyopts")
-		}
-		if opts[0] == opts[1] && value == "" {
-			// There are some weird empty diffs against itself in my dataset.
-			// Better just ignore them.
-			return nil
+	case "csum":
+		pfr := parser.revField(unix)
+		if pfr.Summary != "" {
+			return fmt.Errorf("csum field was already set")
 		}
+		pfr.Summary = value
 
-		if pfr.DiffAgainst != (time.Time{}) || len(pfr.Diff) > 0 {
-			return fmt.Errorf("diff field was already set")
-		}
+	default:
+		// unknown / unsupported item
+		parser.unknown(key, value, opts)
+	}
 
-		if diffAgainstUnix, err := strconv.ParseInt(opts[1], 10, 64); err != nil {
-			return fmt.Errorf("time parsing errored, %w", err)
+	return nil
+}
+
+// pageFileParseDiff parses a diff field's raw value into a PageFileRevision. A skipped value was dropped by the lexer
+// for WithHeaderOnly, but was not empty.
+func pageFileParseDiff(parser *pageFileParser, raw string, skipped bool, opts []string) error {
+	// As in pageFileParseRev, items without a time as their first pageFileKeyOpt will be ignored.
+	unixInt, unixErr := strconv.ParseInt(opts[0], 10, 64)
+	if unixErr != nil {
+		if value, err := parser.decode(raw); err != nil {
+			return fmt.Errorf("URL decoding value errored, %w", err)
 		} else {
-			pfr.DiffAgainst = time.Unix(diffAgainstUnix, 0).UTC()
+			parser.unknown("diff", value, opts)
 		}
-		if patch, err := parsePatch(strings.ReplaceAll(value, "\\ No newline at end of file\n", "")); err != nil {
+		return nil
+	}
+
+	if len(opts) < 2 {
+		return fmt.Errorf("diff requires at least two keyopts")
+	}
+	if opts[0] == opts[1] && raw == "" && !skipped {
+		// There are some weird empty diffs against itself in my dataset.
+		// Better just ignore them, but keep them to be written back. However, an unchanged save within the same
+		// second results in such a diff as well, which is restored by finishRevs.
+		parser.unknown("diff", raw, opts)
+		parser.emptyDiffs = append(parser.emptyDiffs, len(parser.pf.Unknown)-1)
+		return nil
+	}
+
+	diffRev := PageFileRevision{Time: time.Unix(unixInt, 0).UTC()}
+	if diffAgainstUnix, err := strconv.ParseInt(opts[1], 10, 64); err != nil {
+		return fmt.Errorf("time parsing errored, %w", err)
+	} else {
+		diffRev.DiffAgainst = time.Unix(diffAgainstUnix, 0).UTC()
+	}
+	diffRev.Minor = len(opts) > 2 && opts[2] == "minor"
+
+	diffKey := [2]int64{diffRev.Time.Unix(), diffRev.DiffAgainst.Unix()}
+	if parser.diffs[diffKey] {
+		return fmt.Errorf("diff field was already set")
+	}
+	parser.diffs[diffKey] = true
+
+	if parser.options.lazyDiffs && !parser.options.headerOnly {
+		diffRev.lazyDiff = &pageFileLazyDiff{
+			raw:        raw,
+			newline:    parser.newline,
+			urlencoded: parser.urlencoded,
+			strict:     parser.options.strictPatches,
+		}
+	} else if !parser.options.headerOnly {
+		patch, err := parsePatchReader(parser.valueReader(raw), parser.options.strictPatches)
+		if err != nil {
 			return fmt.Errorf("parsing diff errored, %w", err)
-		} else {
-			pfr.Diff = patch
 		}
+		diffRev.Diff = patch
+	}
+
+	parser.pf.Revs = append(parser.pf.Revs, diffRev)
+	return nil
+}
+
+// decode a raw value by replacing a legacy newline encoding and URL decoding it, based on the page file's version.
+func (parser *pageFileParser) decode(raw string) (value string, err error) {
+	value = raw
+	if parser.newline != "" {
+		value = strings.ReplaceAll(value, parser.newline, "\n")
+	}
+	if parser.urlencoded {
+		value, err = url.QueryUnescape(strings.ReplaceAll(value, "+", "%2b"))
+	}
+	return
+}
+
+// valueReader decodes a raw value while it is being read, resulting in the same data as decode.
+func (parser *pageFileParser) valueReader(raw string) *bufio.Reader {
+	return bufio.NewReader(&pageFileValueReader{
+		value:      raw,
+		newline:    parser.newline,
+		urlencoded: parser.urlencoded,
+	})
+}
+
+// pageFileValueReader is an io.Reader decoding a raw value, see pageFileParser.valueReader.
+type pageFileValueReader struct {
+	value      string
+	newline    string
+	urlencoded bool
+
+	pos int
+}
+
+func (reader *pageFileValueReader) Read(p []byte) (n int, err error) {
+	for ; n < len(p) && reader.pos < len(reader.value); n++ {
+		rest := reader.value[reader.pos:]
+
+		switch {
+		case reader.newline != "" && strings.HasPrefix(rest, reader.newline):
+			p[n] = '\n'
+			reader.pos += len(reader.newline)
+
+		case reader.urlencoded && rest[0] == '%':
+			if len(rest) < 3 || !isHex(rest[1]) || !isHex(rest[2]) {
+				if len(rest) > 3 {
+					rest = rest[:3]
+				}
+				return n, url.EscapeError(rest)
+			}
+			p[n] = unhex(rest[1])<<4 | unhex(rest[2])
+			reader.pos += 3
+
+		default:
+			p[n] = rest[0]
+			reader.pos++
+		}
+	}
+
+	if n == 0 && len(p) > 0 {
+		err = io.EOF
+	}
+	return
+}
+
+// isHex checks if a byte is an ASCII hexadecimal digit.
+func isHex(b byte) bool {
+	return isDigit(b) || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
 
+// unhex converts an ASCII hexadecimal digit into its value.
+func unhex(b byte) byte {
+	switch {
+	case isDigit(b):
+		return b - '0'
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10
 	default:
-		// unknown / unsupported item
-		// return, because we would save the current revision otherwise
-		return nil
+		return b - 'A' + 10
 	}
+}
 
-	parser.pf.Revs[unix] = pfr
-	return nil
+// revField returns the shared revision fields for the given second.
+func (parser *pageFileParser) revField(unix time.Time) *PageFileRevision {
+	pfr, ok := parser.revFields[unix]
+	if !ok {
+		pfr = &PageFileRevision{Time: unix}
+		parser.revFields[unix] = pfr
+	}
+	return pfr
+}
+
+// finishRevs merges the shared revision fields into the diffs' revisions and orders them.
+//
+// Revision fields without any diff result in a revision on their own to be written back.
+//
+// An empty diff against itself is only ignored if no other diff shares its second. Otherwise, it is a save within
+// this second without any changes, as created by AddRevision, and is restored as a revision.
+func (parser *pageFileParser) finishRevs() {
+	for i := len(parser.emptyDiffs) - 1; i >= 0; i-- {
+		idx := parser.emptyDiffs[i]
+		field := parser.pf.Unknown[idx]
+
+		unix, _ := strconv.ParseInt(field.Opts[0], 10, 64)
+		at := time.Unix(unix, 0).UTC()
+		if parser.pf.revisionIndex(at, 0) < 0 {
+			continue
+		}
+
+		parser.pf.Unknown = append(parser.pf.Unknown[:idx], parser.pf.Unknown[idx+1:]...)
+		parser.pf.Revs = append(parser.pf.Revs, PageFileRevision{
+			Time:        at,
+			DiffAgainst: at,
+			Minor:       len(field.Opts) > 2 && field.Opts[2] == "minor",
+		})
+	}
+
+	merged := make(map[time.Time]bool)
+	for i := range parser.pf.Revs {
+		rev := &parser.pf.Revs[i]
+		if fields, ok := parser.revFields[rev.Time]; ok {
+			rev.Author, rev.Host, rev.Summary = fields.Author, fields.Host, fields.Summary
+			merged[rev.Time] = true
+		}
+	}
+	for unix, fields := range parser.revFields {
+		if !merged[unix] {
+			parser.pf.Revs = append(parser.pf.Revs, *fields)
+		}
+	}
+
+	parser.pf.sortRevisions()
 }
 
 // ParsePageFile parses PmWiki's PageFileFormat into a PageFile.
-func ParsePageFile(r io.Reader) (PageFile, error) {
+//
+// By default, all values are returned as they are stored. A charset conversion can be enabled by either WithCharset
+// or WithAutoCharset. Diffs are validated more thoroughly by WithStrictPatches. The text and diffs might be skipped by
+// WithHeaderOnly, as ParsePageFileHeader does, or be parsed on demand by WithLazyDiffs.
+func ParsePageFile(r io.Reader, opts ...PageFileOption) (PageFile, error) {
 	parser := &pageFileParser{
-		pf:       PageFile{Revs: make(map[time.Time]PageFileRevision)},
-		lexItems: lexPageFile(r),
+		pf: PageFile{
+			present: make(map[string]bool),
+			texts:   &pageFileTexts{},
+		},
+		revFields: make(map[time.Time]*PageFileRevision),
+		diffs:     make(map[[2]int64]bool),
+		options:   newPageFileOptions(opts),
+		lexer:     lexPageFile(r),
+	}
+	if parser.options.headerOnly {
+		parser.pf.headerOnly = true
+		parser.lexer.skip = map[string]bool{"text": true, "diff": true}
 	}
 
 	for state := pageFileParseVersion; state != nil; state = state(parser) {
 	}
+	if parser.err != nil {
+		return parser.pf, parser.err
+	}
+
+	if charset := parser.options.pageFileCharset(parser.pf); charset != "" {
+		if decoder, _, err := charsetConverters(charset); err != nil {
+			return PageFile{}, err
+		} else if decoder != nil {
+			if pf, err := parser.pf.convert(decoder); err != nil {
+				return PageFile{}, fmt.Errorf("decoding %s errored, %w", charset, err)
+			} else {
+				parser.pf = pf
+				parser.pf.decodedCharset = charset
+			}
+		}
+	}
+
+	return parser.pf, nil
+}
 
-	return parser.pf, parser.err
+// ParsePageFileHeader parses only the metadata of PmWiki's PageFileFormat into a PageFile, as described for
+// WithHeaderOnly. This is considerably faster than ParsePageFile for pages with a long text or history.
+func ParsePageFileHeader(r io.Reader, opts ...PageFileOption) (PageFile, error) {
+	return ParsePageFile(r, append(opts, WithHeaderOnly())...)
 }
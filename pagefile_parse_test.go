@@ -5,9 +5,14 @@
 package pmwiki
 
 import (
+	"io"
+	"io/ioutil"
 	"net"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -35,7 +40,7 @@ func TestParsePageFileValid(t *testing.T) {
 	input5 := "version=pmwiki-2.1.0 urlencoded=1\ntext=text\nhost:42=::1\ndiff:42:23:=0a1%0a> add%0a\n"
 	check5 := func(pf PageFile) bool {
 		pfrUnix := time.Unix(42, 0).UTC()
-		if pfr, ok := pf.Revs[pfrUnix]; !ok {
+		if pfr, ok := pf.Revision(pfrUnix); !ok {
 			return false
 		} else {
 			return pfr.Host.Equal(net.ParseIP("::1")) &&
@@ -64,7 +69,7 @@ func TestParsePageFileValid(t *testing.T) {
 		pfrB := time.Unix(10, 0).UTC()
 		pfrA := time.Unix(5, 0).UTC()
 
-		if pfr, ok := pf.Revs[pfrB]; !ok {
+		if pfr, ok := pf.Revision(pfrB); !ok {
 			return false
 		} else {
 			chk := pfr.Author == "foo" &&
@@ -75,7 +80,7 @@ func TestParsePageFileValid(t *testing.T) {
 			}
 		}
 
-		if pfr, ok := pf.Revs[pfrA]; !ok {
+		if pfr, ok := pf.Revision(pfrA); !ok {
 			return false
 		} else {
 			return pfr.Author == "bar" &&
@@ -98,9 +103,61 @@ func TestParsePageFileValid(t *testing.T) {
 
 	input11 := "version=pmwiki-2.1.0\ntext=foo\nauthor:1527448031=user\ndiff:1527448031:1527446923:=\nhost:1527448031=fc80::1\n"
 	check11 := func(pf PageFile) bool {
-		return len(pf.Revs) == 1 && pf.Revs[time.Unix(1527448031, 0).UTC()].DiffAgainst != (time.Time{})
+		pfr, ok := pf.Revision(time.Unix(1527448031, 0).UTC())
+		return len(pf.Revs) == 1 && ok && pfr.DiffAgainst != (time.Time{})
 	}
 
+	input12 := "version=pmwiki-2.1.0 urlencoded=1\ntext=text\ncsum:42=fixed typo\ndiff:42:23:minor=\ndiff:23:5:=\n"
+	check12 := func(pf PageFile) bool {
+		pfr, ok := pf.Revision(time.Unix(42, 0).UTC())
+		pfrOld, okOld := pf.Revision(time.Unix(23, 0).UTC())
+		return ok && pfr.Summary == "fixed typo" && pfr.Minor && okOld && !pfrOld.Minor
+	}
+
+	input13 := "version=pmwiki-2.1.0 urlencoded=1\ntext=text\npasswdread=foo%0abar\nfoo:bar:buz=qux\nfoo:23=42\npasswdread=again\n"
+	check13 := func(pf PageFile) bool {
+		return reflect.DeepEqual(pf.Unknown, []PageFileField{
+			{Key: "passwdread", Value: "foo\nbar"},
+			{Key: "foo", Opts: []string{"bar", "buz"}, Value: "qux"},
+			{Key: "foo", Opts: []string{"23"}, Value: "42"},
+			{Key: "passwdread", Value: "again"},
+		})
+	}
+
+	input14 := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\nagent=Mozilla/5.0\ncharset=UTF-8\ncsum=typo\n" +
+		"ctime=1603390737\ndescription=A test%0apage\nkeywords=foo, bar\ntargets=Main.Foo,Main.Bar\n" +
+		"title=Test\nupdatedby=oxzi\nupdatedto=2.2.106\n"
+	check14 := func(pf PageFile) bool {
+		return pf.Agent == "Mozilla/5.0" &&
+			pf.Charset == "UTF-8" &&
+			pf.Summary == "typo" &&
+			pf.CreateTime.Equal(time.Unix(1603390737, 0).UTC()) &&
+			pf.Description == "A test\npage" &&
+			pf.Keywords == "foo, bar" &&
+			reflect.DeepEqual(pf.Targets, []string{"Main.Foo", "Main.Bar"}) &&
+			pf.Title == "Test" &&
+			pf.UpdatedBy == "oxzi" &&
+			pf.UpdatedTo == "2.2.106" &&
+			len(pf.Unknown) == 0
+	}
+
+	input15 := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\ncsum=\ntargets=\n"
+	check15 := func(pf PageFile) bool { return pf.Summary == "" && pf.Targets == nil && len(pf.Unknown) == 0 }
+
+	input16 := "version=pmwiki-1.0.13 newline=\262\ntext=foo\262bar%0a\262\ntime=20\n" +
+		"diff:20:10:=2c2\262< bar%0a\262---\262> buz\262\ndiff:10:10:=1,2d0\262< foo\262< buz\262\n"
+	check16 := func(pf PageFile) bool {
+		var texts []string
+		err := pf.Revisions(func(view PageFile) { texts = append(texts, view.Text) })
+		return pf.Text == "foo\nbar%0a\n" && err == nil && reflect.DeepEqual(texts, []string{"foo\nbar%0a\n", "foo\nbuz\n"})
+	}
+
+	input17 := "version=pmwiki-1.0.13\nnewline=\262\ntext=foo\262bar\n"
+	check17 := func(pf PageFile) bool { return pf.Text == "foo\nbar" && len(pf.Unknown) == 0 }
+
+	input18 := "version=pmwiki-0.6.20\ntext=foo\262bar\n"
+	check18 := func(pf PageFile) bool { return pf.Text == "foo\nbar" }
+
 	tests := []struct {
 		name  string
 		input string
@@ -117,6 +174,13 @@ func TestParsePageFileValid(t *testing.T) {
 		{"check URL encoding", input9, check9},
 		{"check disabled URL encoding", input10, check10},
 		{"empty diff", input11, check11},
+		{"revision summary and minor flag", input12, check12},
+		{"keep unknown fields", input13, check13},
+		{"metadata fields", input14, check14},
+		{"empty metadata fields", input15, check15},
+		{"legacy newline attribute", input16, check16},
+		{"legacy newline field", input17, check17},
+		{"legacy PmWiki 0.x newline", input18, check18},
 	}
 
 	for _, test := range tests {
@@ -148,9 +212,14 @@ func TestParsePageFileInvalid(t *testing.T) {
 		{"invalid host", "version=pmwiki-2.1.0 urlencoded=1\nhost=dtn://host/\n"},
 		{"double rev", "version=pmwiki-2.1.0 urlencoded=1\nrev=1\nrev=2\n"},
 		{"invalid rev", "version=pmwiki-2.1.0 urlencoded=1\nrev=latest and greatest\n"},
+		{"double ctime", "version=pmwiki-2.1.0 urlencoded=1\nctime=123456\nctime=1234567\n"},
+		{"invalid ctime", "version=pmwiki-2.1.0 urlencoded=1\nctime=yesterday\n"},
+		{"double targets", "version=pmwiki-2.1.0 urlencoded=1\ntargets=\ntargets=Main.Foo\n"},
+		{"double title", "version=pmwiki-2.1.0 urlencoded=1\ntitle=\ntitle=foo\n"},
 		{"rev, double author", "version=pmwiki-2.1.0 urlencoded=1\nauthor:23=foo\nauthor:23=bar\n"},
 		{"rev, double host", "version=pmwiki-2.1.0 urlencoded=1\nhost:23=2001:db8::1\nhost:23=172.23.42.128\n"},
 		{"rev, invalid host", "version=pmwiki-2.1.0 urlencoded=1\nhost:23=dtn://host/\n"},
+		{"rev, double csum", "version=pmwiki-2.1.0 urlencoded=1\ncsum:23=foo\ncsum:23=bar\n"},
 		{"rev, double diff", "version=pmwiki-2.1.0 urlencoded=1\ndiff:42:23:=foo\ndiff:42:23:=bar\n"},
 		{"rev, diff less keyopts", "version=pmwiki-2.1.0 urlencoded=1\ndiff:42=foo\n"},
 		{"rev, diff invalid against", "version=pmwiki-2.1.0 urlencoded=1\ndiff:42:old:=foo\n"},
@@ -165,3 +234,181 @@ func TestParsePageFileInvalid(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePageFileStrictPatches(t *testing.T) {
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\ntext=a\ntime=20\ndiff:20:10:=1,2c1%0a%3c a%0a---%0a> b%0a\n"
+
+	if _, err := ParsePageFile(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePageFile(strings.NewReader(input), WithStrictPatches()); err == nil {
+		t.Fatal("inconsistent diff was accepted")
+	} else if !strings.Contains(err.Error(), "hunk 1 at line 1") {
+		t.Fatalf("error lacks the hunk position, %v", err)
+	}
+}
+
+func TestParsePageFileNoGoroutineLeak(t *testing.T) {
+	inputs := []string{
+		"name=Main.HomePage\n",
+		"version=pmwiki-2.2.130 ordered=1 urlencoded=1\nname=Main.HomePage\nname=Main.HomePage\ntext=foo\n",
+		"version=pmwiki-2.2.130 ordered=1 urlencoded=1\ndiff:2:1:=1x%0a> foo%0a\ntext=foo\n",
+		"version=pmwiki-2.2.130 ordered=1 urlencoded=1\ndiff:2:1:=1,2d0%0a< foo%0a\ntext=foo\n",
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		for _, input := range inputs {
+			if _, err := ParsePageFile(strings.NewReader(input), WithStrictPatches()); err == nil {
+				t.Fatalf("parsing %q did not fail", input)
+			}
+		}
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("%d goroutines before parsing, %d afterwards", before, after)
+	}
+}
+
+func TestPageFileValueReader(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		newline    string
+		urlencoded bool
+	}{
+		{"plain", "foo bar", "", false},
+		{"urlencoded", "1c1%0a< foo%0a---%0a> %3cbar%25%0a", "", true},
+		{"plus", "a+b%2bc", "", true},
+		{"legacy newline", "foo\262bar\262", "\262", false},
+		{"legacy newline and urlencoded", "foo\262%3c\262", "\262", true},
+		{"invalid escape", "foo%zzbar", "", true},
+		{"truncated escape", "foo%2", "", true},
+		{"long", strings.Repeat("lorem ipsum%0a", 1000), "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := &pageFileParser{newline: test.newline, urlencoded: test.urlencoded}
+
+			expected, expectedErr := parser.decode(test.raw)
+			value, err := ioutil.ReadAll(iotest.OneByteReader(parser.valueReader(test.raw)))
+
+			if (expectedErr == nil) != (err == nil) {
+				t.Fatalf("errors differ, %v and %v", expectedErr, err)
+			} else if expectedErr == nil && expected != string(value) {
+				t.Fatalf("decoded %q, expected %q", value, expected)
+			}
+		})
+	}
+}
+
+func TestParsePageFileHeader(t *testing.T) {
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nauthor=bar\nname=Main.HomePage\nrev=2\n" +
+		"text=new text%0a\ntime=30\n" +
+		"author:30=bar\ndiff:30:20:=1c1%0a< old text%0a---%0a> new text%0a\n" +
+		"author:20=foo\ndiff:20:20:=0a1%0a> old text%0a\ndiff:10:10:=\n"
+
+	full, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ParsePageFileHeader(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Text != "" {
+		t.Fatalf("text %q was parsed", header.Text)
+	}
+	if header.Name != full.Name || !header.Time.Equal(full.Time) || header.Author != full.Author ||
+		header.Rev != full.Rev {
+		t.Fatalf("header %v differs from %v", header, full)
+	}
+
+	if len(header.Revs) != len(full.Revs) {
+		t.Fatalf("%d revisions instead of %d", len(header.Revs), len(full.Revs))
+	}
+	for i, rev := range header.Revs {
+		if rev.Diff != nil {
+			t.Fatalf("revision %d has a diff %v", i, rev.Diff)
+		}
+
+		rev.Diff = full.Revs[i].Diff
+		if !reflect.DeepEqual(rev, full.Revs[i]) {
+			t.Fatalf("revision %d differs, %v and %v", i, rev, full.Revs[i])
+		}
+	}
+
+	if !reflect.DeepEqual(header.Unknown, full.Unknown) || len(header.Unknown) != 1 {
+		t.Fatalf("unknown fields %v differ from %v", header.Unknown, full.Unknown)
+	}
+
+	failing := []struct {
+		name string
+		f    func() error
+	}{
+		{"Revisions", func() error { return header.Revisions(func(PageFile) {}) }},
+		{"RevisionsLenient", func() error { return header.RevisionsLenient(func(PageFile) {}) }},
+		{"History", func() error { history := header.History(); history.Next(); return history.Err() }},
+		{"TextAtRev", func() error { _, err := header.TextAtRev(1); return err }},
+		{"TextAt", func() error { _, err := header.TextAt(time.Unix(20, 0)); return err }},
+		{"WritePageFile", func() error { return WritePageFile(ioutil.Discard, header) }},
+		{"AddRevision", func() error { return header.AddRevision("newer text", "", nil, time.Unix(40, 0), "") }},
+	}
+	for _, f := range failing {
+		if err := f.f(); err == nil {
+			t.Fatalf("%s did not fail for a header", f.name)
+		}
+	}
+}
+
+func TestParsePageFileHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not starting with version", "foo=bar\nversion=pmwiki-2.1.0 urlencoded=1\n"},
+		{"early eof", "version=pmwiki-2.1.0 urlencoded=1\ntext=Markup text"},
+		{"double text", "version=pmwiki-2.1.0 urlencoded=1\ntext=foo\ntext=bar\n"},
+		{"rev, double diff", "version=pmwiki-2.1.0 urlencoded=1\ndiff:42:23:=foo\ndiff:42:23:=bar\n"},
+		{"rev, diff less keyopts", "version=pmwiki-2.1.0 urlencoded=1\ndiff:42=foo\n"},
+		{"rev, diff invalid against", "version=pmwiki-2.1.0 urlencoded=1\ndiff:42:old:=foo\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pf, err := ParsePageFileHeader(strings.NewReader(test.input)); err == nil {
+				t.Fatalf("did not fail, produced %v", pf)
+			}
+		})
+	}
+}
+
+func BenchmarkParsePageFile(b *testing.B) {
+	input := syntheticPageFile(1<<20, 1000)
+
+	benchmarks := []struct {
+		name  string
+		parse func(io.Reader, ...PageFileOption) (PageFile, error)
+	}{
+		{"full", ParsePageFile},
+		{"header", ParsePageFileHeader},
+		{"lazy", func(r io.Reader, opts ...PageFileOption) (PageFile, error) {
+			return ParsePageFile(r, append(opts, WithLazyDiffs())...)
+		}},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+
+			for i := 0; i < b.N; i++ {
+				if _, err := benchmark.parse(strings.NewReader(input)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -13,8 +13,18 @@ func (pfs ByTime) Len() int {
 }
 
 // Less is true iff one PageFile's Time is before another ones.
+//
+// PageFiles of the same second are ordered by their Name. Only revisions of the same page are ordered by their Rev, as
+// the Rev counters of different pages are unrelated.
 func (pfs ByTime) Less(i, j int) bool {
-	return pfs[i].Time.Before(pfs[j].Time)
+	switch {
+	case !pfs[i].Time.Equal(pfs[j].Time):
+		return pfs[i].Time.Before(pfs[j].Time)
+	case pfs[i].Name != pfs[j].Name:
+		return pfs[i].Name < pfs[j].Name
+	default:
+		return pfs[i].Rev < pfs[j].Rev
+	}
 }
 
 // Swap the position of two PageFiles.
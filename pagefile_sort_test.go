@@ -5,6 +5,8 @@
 package pmwiki
 
 import (
+	"fmt"
+	"reflect"
 	"sort"
 	"testing"
 	"time"
@@ -29,3 +31,23 @@ func TestPageFileSortByTime(t *testing.T) {
 		}
 	}
 }
+
+func TestPageFileSortByTimeSameSecond(t *testing.T) {
+	var pfs []PageFile
+	for rev := 5; rev >= 1; rev-- {
+		pfs = append(pfs, PageFile{Name: "Main.Foo", Time: time.Unix([]int64{10, 20, 20, 20, 30}[rev-1], 0), Rev: rev})
+	}
+	pfs = append(pfs, PageFile{Name: "Main.Bar", Time: time.Unix(20, 0), Rev: 7})
+	pfs = append(pfs, PageFile{Name: "Main.Baz", Time: time.Unix(20, 0), Rev: 1})
+
+	sort.SliceStable(pfs, ByTime(pfs).Less)
+
+	var order []string
+	for _, pf := range pfs {
+		order = append(order, fmt.Sprintf("%s:%d", pf.Name, pf.Rev))
+	}
+	expected := []string{"Main.Foo:1", "Main.Bar:7", "Main.Baz:1", "Main.Foo:2", "Main.Foo:3", "Main.Foo:4", "Main.Foo:5"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+}
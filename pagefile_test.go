@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPageFile creates a PageFile by adding a revision of "user" for each text, starting at time 10 and ten seconds
+// apart from each other.
+func testPageFile(t *testing.T, texts ...string) (pf PageFile) {
+	t.Helper()
+
+	for i, text := range texts {
+		if err := pf.AddRevision(text, "user", nil, time.Unix(int64(10*(i+1)), 0), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+// testPageFileLines creates a PageFile by testPageFile with the given amount of revisions; the i-th revision's text
+// is "line i".
+func testPageFileLines(t *testing.T, revs int) PageFile {
+	t.Helper()
+
+	texts := make([]string, revs)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("line %d\n", i+1)
+	}
+	return testPageFile(t, texts...)
+}
+
+// testRoundTrip writes a PageFile and parses it again.
+func testRoundTrip(t *testing.T, pf PageFile) PageFile {
+	t.Helper()
+
+	var out strings.Builder
+	if err := WritePageFile(&out, pf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePageFile(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestPageFileAddRevision(t *testing.T) {
+	texts := []string{"hello", "hello\nworld", "", "hello world\n"}
+
+	var pf PageFile
+	for i, text := range texts {
+		at := time.Unix(int64(100*(i+1)), 0)
+		if err := pf.AddRevision(text, "user", net.ParseIP("::1"), at, "edit"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pf.Rev != len(texts) || pf.Text != texts[len(texts)-1] || !pf.Time.Equal(time.Unix(400, 0)) ||
+		pf.Summary != "edit" || !pf.CreateTime.Equal(time.Unix(100, 0)) {
+		t.Fatalf("unexpected main fields, %v", pf)
+	}
+	if first, _ := pf.Revision(time.Unix(100, 0).UTC()); !first.DiffAgainst.Equal(first.Time) {
+		t.Fatalf("first revision is not diffed against itself, %v", first)
+	}
+
+	parsed := testRoundTrip(t, pf)
+
+	for _, pageFile := range []PageFile{pf, parsed} {
+		text := pageFile.Text
+		for i := len(texts) - 1; i >= 0; i-- {
+			if text != texts[i] {
+				t.Fatalf("revision %d: expected %q, got %q", i, texts[i], text)
+			}
+
+			rev, _ := pageFile.Revision(time.Unix(int64(100*(i+1)), 0).UTC())
+			if rev.Author != "user" || rev.Summary != "edit" || !rev.Host.Equal(net.ParseIP("::1")) {
+				t.Fatalf("revision %d: unexpected metadata %v", i, rev)
+			}
+
+			var textOut strings.Builder
+			if err := rev.Diff.Apply(strings.NewReader(text), &textOut); err != nil {
+				t.Fatal(err)
+			}
+			text = textOut.String()
+		}
+		if text != "" {
+			t.Fatalf("initial text is not empty, %q", text)
+		}
+	}
+}
+
+func TestPageFileRevisionsMetadata(t *testing.T) {
+	input := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\nauthor=foo\ncsum=typo\nname=Main.Test\nrev=2\n" +
+		"text=hello world\ntime=20\n" +
+		"author:20=foo\ncsum:20=typo\ndiff:20:10:minor=1c1%0a%3c hello world%0a---%0a> hello%0a\n" +
+		"author:10=bar\ncsum:10=created%0apage\ndiff:10:10:=1d0%0a%3c hello%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var views []PageFile
+	if err := pf.Revisions(func(view PageFile) { views = append(views, view) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(views) != 2 {
+		t.Fatalf("expected two views, got %d", len(views))
+	}
+	if v := views[0]; v.Author != "foo" || v.Summary != "typo" || !v.Minor || v.Text != "hello world" {
+		t.Fatalf("unexpected first view %v", v)
+	}
+	if v := views[1]; v.Author != "bar" || v.Summary != "created\npage" || v.Minor || v.Text != "hello\n" {
+		t.Fatalf("unexpected second view %v", v)
+	}
+}
+
+func TestPageFileAddRevisionInvalid(t *testing.T) {
+	var pf PageFile
+	if err := pf.AddRevision("foo", "", nil, time.Unix(100, 0), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pf.AddRevision("bar", "", nil, time.Unix(50, 0), ""); err == nil {
+		t.Fatal("older revision was accepted")
+	}
+	if err := pf.AddRevision("bar", "", nil, time.Unix(100, 0), ""); err == nil {
+		t.Fatal("revision of the same time was accepted")
+	}
+}
+
+func TestPageFileRevisionsSameSecond(t *testing.T) {
+	var pf PageFile
+	for i, text := range []string{"a", "b", "c"} {
+		at := time.Unix([]int64{10, 20, 20}[i], 0)
+		if err := pf.AddRevision(text, "user", nil, at, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	if err := WritePageFile(&out, pf); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(out.String(), "\ndiff:20:10:=") || !strings.Contains(out.String(), "\ndiff:20:20:=") {
+		t.Fatalf("diffs within the same second are missing:\n%s", out.String())
+	}
+
+	parsed, err := ParsePageFile(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	if err := parsed.Revisions(func(view PageFile) { texts = append(texts, view.Text) }); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(texts, []string{"c", "b", "a"}) {
+		t.Fatalf("unexpected revisions %q", texts)
+	}
+
+	var outParsed strings.Builder
+	if err := WritePageFile(&outParsed, parsed); err != nil {
+		t.Fatal(err)
+	} else if outParsed.String() != out.String() {
+		t.Fatalf("expected:\n%s\ngot:\n%s", out.String(), outParsed.String())
+	}
+
+	// An unchanged save within the same second results in an empty diff against itself, which must not be ignored.
+	unchanged := testPageFile(t, "a", "b")
+	if err := unchanged.AddRevision("b", "user", nil, time.Unix(20, 0), ""); err != nil {
+		t.Fatal(err)
+	}
+	unchanged = testRoundTrip(t, unchanged)
+
+	var views []PageFile
+	if err := unchanged.Revisions(func(view PageFile) { views = append(views, view) }); err != nil {
+		t.Fatal(err)
+	} else if len(views) != 3 {
+		t.Fatalf("expected three revisions, got %d", len(views))
+	}
+	for i, text := range []string{"b", "b", "a"} {
+		if views[i].Text != text || views[i].Rev != 3-i {
+			t.Fatalf("view %d: unexpected %v", i, views[i])
+		}
+	}
+	if text, err := unchanged.TextAtRev(1); err != nil || text != "a" {
+		t.Fatalf("revision 1 is %q, %v", text, err)
+	}
+}
+
+func TestPageFileRevisionsBlank(t *testing.T) {
+	texts := []string{"a", "", "b", "", "c"}
+	pf := testPageFile(t, texts...)
+
+	parsed := testRoundTrip(t, pf)
+
+	for _, pageFile := range []PageFile{pf, parsed} {
+		var views []PageFile
+		if err := pageFile.Revisions(func(view PageFile) { views = append(views, view) }); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(views) != len(texts) {
+			t.Fatalf("expected %d views, got %d", len(texts), len(views))
+		}
+		for i, view := range views {
+			j := len(texts) - 1 - i
+			if view.Text != texts[j] || view.Rev != j+1 || !view.Time.Equal(time.Unix(int64(10*(j+1)), 0)) {
+				t.Fatalf("view %d: unexpected %v", i, view)
+			}
+		}
+	}
+}
+
+func TestPageFileRevisionsMissing(t *testing.T) {
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nname=Main.Test\nrev=2\ntext=b\ntime=20\n" +
+		"diff:20:10:=1c1%0a%3c b%0a---%0a> a%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var views int
+	if err := pf.Revisions(func(PageFile) { views++ }); err == nil {
+		t.Fatal("missing revision was not reported")
+	} else if views != 1 {
+		t.Fatalf("expected one view before the error, got %d", views)
+	}
+}
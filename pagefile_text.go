@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// pageFileTextCacheSize is the maximum amount of texts within a pageFileTextCache.
+	pageFileTextCacheSize = 16
+	// pageFileTextCacheInterval is the distance of intermediate texts to be cached while walking the chain.
+	pageFileTextCacheInterval = 32
+)
+
+// pageFileTextCache keeps some reconstructed texts of a PageFile's revision chain, see TextAtRev.
+type pageFileTextCache struct {
+	// chain of revision indices, as returned by revisionChain.
+	chain []int
+
+	// text, time and revs are the PageFile's Text, Time and amount of Revs when this cache was created.
+	text string
+	time time.Time
+	revs int
+
+	// texts by their chain position and these positions from the least to the most recently used one.
+	texts map[int]string
+	used  []int
+}
+
+// get a cached text and mark it as recently used.
+func (cache *pageFileTextCache) get(pos int) (text string, ok bool) {
+	if text, ok = cache.texts[pos]; ok {
+		cache.put(pos, text)
+	}
+	return
+}
+
+// put a text into the cache, evicting the least recently used one if necessary.
+func (cache *pageFileTextCache) put(pos int, text string) {
+	for i, usedPos := range cache.used {
+		if usedPos == pos {
+			cache.used = append(cache.used[:i], cache.used[i+1:]...)
+			break
+		}
+	}
+
+	if _, ok := cache.texts[pos]; !ok && len(cache.used) >= pageFileTextCacheSize {
+		delete(cache.texts, cache.used[0])
+		cache.used = cache.used[1:]
+	}
+
+	cache.texts[pos] = text
+	cache.used = append(cache.used, pos)
+}
+
+// matches checks if this cache was created for the PageFile's current Text, Time and Revs.
+func (cache *pageFileTextCache) matches(pageFile *PageFile) bool {
+	return cache.text == pageFile.Text && cache.time.Equal(pageFile.Time) && cache.revs == len(pageFile.Revs)
+}
+
+// pageFileTexts holds the pageFileTextCache of a PageFile. It is allocated when the PageFile is built, i.e., by
+// ParsePageFile and AddRevision, and is shared between copies of this PageFile. Thus, the cache is guarded by mu.
+type pageFileTexts struct {
+	mu    sync.Mutex
+	cache *pageFileTextCache
+}
+
+// withTextCache calls a function with this PageFile's pageFileTextCache, which is created on demand. The cache is
+// locked while the function is being called.
+//
+// The PageFile itself is never modified. Thus, a PageFile without pageFileTexts, e.g., being created literally, gets
+// a new cache for each call.
+func (pageFile *PageFile) withTextCache(f func(cache *pageFileTextCache) (string, error)) (string, error) {
+	texts := pageFile.texts
+	if texts == nil {
+		texts = &pageFileTexts{}
+	}
+
+	texts.mu.Lock()
+	defer texts.mu.Unlock()
+
+	if texts.cache == nil || !texts.cache.matches(pageFile) {
+		chain, err := pageFile.revisionChain()
+		if err != nil {
+			return "", err
+		}
+		texts.cache = &pageFileTextCache{
+			chain: chain,
+			text:  pageFile.Text,
+			time:  pageFile.Time,
+			revs:  len(pageFile.Revs),
+			texts: make(map[int]string),
+		}
+	}
+	return f(texts.cache)
+}
+
+// textAtPos reconstructs the text at a chain position, starting from the closest known newer text.
+//
+// Texts are only reconstructed from newer to older ones by applying the reverse diffs, as Revisions does. Inverting a
+// diff is lossy, e.g., for deleted lines only matching after trimming whitespace, and would make the result depend on
+// previous queries.
+func (pageFile *PageFile) textAtPos(cache *pageFileTextCache, pos int) (string, error) {
+	if text, ok := cache.get(pos); ok {
+		return text, nil
+	}
+
+	start, text := 0, pageFile.Text
+	for cachedPos, cachedText := range cache.texts {
+		if cachedPos < pos && cachedPos > start {
+			start, text = cachedPos, cachedText
+		}
+	}
+
+	for curr := start; curr < pos; curr++ {
+		var err error
+		if text, err = pageFile.Revs[cache.chain[curr]].applyDiff(text); err != nil {
+			return "", err
+		}
+
+		if next := curr + 1; next != pos && next%pageFileTextCacheInterval == 0 {
+			cache.put(next, text)
+		}
+	}
+
+	cache.put(pos, text)
+	return text, nil
+}
+
+// TextAtRev reconstructs the text of the revision with the given number, as passed by Revisions.
+//
+// Only the necessary part of the DiffAgainst chain is applied, starting from the closest previously reconstructed
+// text. Those texts are cached for PageFiles built by ParsePageFile or AddRevision, making repeated queries cheap.
+// TextAtRev and TextAt are safe for concurrent use, also with other readers of this PageFile. The cache is reset if
+// the Text, Time or amount of Revs have changed, but modifying single revisions in place is not detected.
+func (pageFile *PageFile) TextAtRev(rev int) (string, error) {
+	if err := pageFile.checkContent(); err != nil {
+		return "", err
+	}
+
+	if pageFile.Deleted != (time.Time{}) && rev == pageFile.Rev+1 {
+		return "", nil
+	}
+
+	return pageFile.withTextCache(func(cache *pageFileTextCache) (string, error) {
+		if len(cache.chain) == 0 {
+			if rev != pageFile.Rev {
+				return "", fmt.Errorf("revision %d does not exist", rev)
+			}
+			return pageFile.Text, nil
+		}
+
+		pos := pageFile.Rev - rev
+		if pos < 0 || pos >= len(cache.chain) {
+			return "", fmt.Errorf("revision %d does not exist", rev)
+		}
+		return pageFile.textAtPos(cache, pos)
+	})
+}
+
+// TextAt reconstructs the text at the given time, i.e., of the newest revision not being newer, as TextAtRev does.
+//
+// An error is returned if the page did not exist at this time or its history has expired.
+func (pageFile *PageFile) TextAt(at time.Time) (string, error) {
+	if err := pageFile.checkContent(); err != nil {
+		return "", err
+	}
+
+	if pageFile.Deleted != (time.Time{}) && !at.Before(pageFile.Deleted) {
+		return "", nil
+	}
+
+	return pageFile.withTextCache(func(cache *pageFileTextCache) (string, error) {
+		if len(cache.chain) == 0 {
+			if at.Before(pageFile.Time) {
+				return "", fmt.Errorf("no revision exists at %v", at)
+			}
+			return pageFile.Text, nil
+		}
+
+		for pos, i := range cache.chain {
+			if !pageFile.Revs[i].Time.After(at) {
+				return pageFile.textAtPos(cache, pos)
+			}
+		}
+		return "", fmt.Errorf("no revision exists at %v", at)
+	})
+}
@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageFileTextAt(t *testing.T) {
+	texts := make([]string, 100)
+	for i := range texts {
+		if rev := i + 1; rev%10 != 0 {
+			texts[i] = fmt.Sprintf("line %d\n", rev)
+		}
+	}
+	pf := testPageFile(t, texts...)
+
+	expected := func(rev int) string {
+		if rev%10 == 0 {
+			return ""
+		}
+		return fmt.Sprintf("line %d\n", rev)
+	}
+
+	for _, rev := range []int{100, 1, 50, 51, 49, 99, 2, 75, 75, 1} {
+		if text, err := pf.TextAtRev(rev); err != nil {
+			t.Fatal(err)
+		} else if text != expected(rev) {
+			t.Fatalf("revision %d: expected %q, got %q", rev, expected(rev), text)
+		}
+
+		if text, err := pf.TextAt(time.Unix(int64(10*rev+5), 0)); err != nil {
+			t.Fatal(err)
+		} else if text != expected(rev) {
+			t.Fatalf("time of revision %d: expected %q, got %q", rev, expected(rev), text)
+		}
+	}
+
+	if len(pf.texts.cache.texts) > pageFileTextCacheSize {
+		t.Fatalf("cache exceeds its size with %d texts", len(pf.texts.cache.texts))
+	}
+
+	for _, rev := range []int{0, 101} {
+		if _, err := pf.TextAtRev(rev); err == nil {
+			t.Fatalf("revision %d was reconstructed", rev)
+		}
+	}
+	if _, err := pf.TextAt(time.Unix(5, 0)); err == nil {
+		t.Fatal("text before the page's creation was reconstructed")
+	}
+
+	if err := pf.AddRevision("new", "user", nil, time.Unix(2000, 0), ""); err != nil {
+		t.Fatal(err)
+	} else if text, err := pf.TextAtRev(101); err != nil || text != "new" {
+		t.Fatalf("text after a new revision is %q, %v", text, err)
+	} else if text, err := pf.TextAtRev(99); err != nil || text != expected(99) {
+		t.Fatalf("text after a new revision is %q, %v", text, err)
+	}
+}
+
+func TestPageFileTextAtDeleted(t *testing.T) {
+	pf := PageFile{Text: "foo", Time: time.Unix(10, 0).UTC(), Rev: 1, Deleted: time.Unix(20, 0).UTC()}
+
+	tests := []struct {
+		at   int64
+		text string
+		err  bool
+	}{
+		{5, "", true},
+		{10, "foo", false},
+		{15, "foo", false},
+		{20, "", false},
+	}
+
+	for _, test := range tests {
+		if text, err := pf.TextAt(time.Unix(test.at, 0)); (err != nil) != test.err || text != test.text {
+			t.Fatalf("%d: unexpected %q, %v", test.at, text, err)
+		}
+	}
+
+	if text, err := pf.TextAtRev(2); err != nil || text != "" {
+		t.Fatalf("deleted revision is %q, %v", text, err)
+	}
+}
+
+func TestPageFileTextAtTrimmedDiff(t *testing.T) {
+	// The diff of revision 2 deletes "a" with a trailing whitespace, which is accepted while being applied.
+	input := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nrev=4\ntext=x%0a\ntime=40\n" +
+		"diff:40:30:=1c1%0a%3c x%0a---%0a> y%0a\n" +
+		"diff:30:20:=1c1%0a%3c y%0a---%0a> a%0a\n" +
+		"diff:20:10:=1c1%0a%3c a %0a---%0a> b%0a\n" +
+		"diff:10:10:=1d0%0a%3c b%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	if err := pf.Revisions(func(view PageFile) { texts = append(texts, view.Text) }); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(texts, []string{"x\n", "y\n", "a\n", "b\n"}) {
+		t.Fatalf("unexpected revisions %q", texts)
+	}
+
+	for _, order := range [][]int{{1, 2, 3, 4}, {4, 3, 2, 1}, {1, 3, 2, 4}, {2, 1, 4, 3}} {
+		pf.texts = &pageFileTexts{}
+		for _, rev := range order {
+			if text, err := pf.TextAtRev(rev); err != nil {
+				t.Fatal(err)
+			} else if expected := texts[len(texts)-rev]; text != expected {
+				t.Fatalf("revision %d after %v: expected %q, got %q", rev, order, expected, text)
+			}
+		}
+	}
+}
+
+func TestPageFileTextAtConcurrent(t *testing.T) {
+	pf := testPageFileLines(t, 100)
+
+	// The cache is created concurrently by the first calls on the same PageFile. Afterwards, copies share it. Other
+	// readers of the PageFile, as Revisions, must not be affected.
+	views := []*PageFile{&pf, &pf, &pf, &pf}
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		errs := make(chan error, len(views)+1)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pf.Revisions(func(PageFile) {}); err != nil {
+				errs <- err
+			}
+		}()
+
+		for i, view := range views {
+			wg.Add(1)
+			go func(pf *PageFile, offset int) {
+				defer wg.Done()
+				for rev := 1 + offset; rev <= pf.Rev; rev += 4 {
+					if text, err := pf.TextAtRev(rev); err != nil {
+						errs <- err
+						return
+					} else if text != fmt.Sprintf("line %d\n", rev) {
+						errs <- fmt.Errorf("revision %d: unexpected %q", rev, text)
+						return
+					}
+				}
+			}(view, i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		for i := range views {
+			view := pf
+			views[i] = &view
+		}
+	}
+}
+
+func TestPageFileTextAtCopies(t *testing.T) {
+	pf := testPageFile(t, "a", "b", "c")
+	if text, err := pf.TextAtRev(2); err != nil || text != "b" {
+		t.Fatalf("revision 2 is %q, %v", text, err)
+	}
+
+	// The copy shares the cache until it is modified.
+	modified := pf
+	if err := modified.AddRevision("d", "user", nil, time.Unix(40, 0), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for rev, text := range []string{"a", "b", "c", "d"} {
+		if got, err := modified.TextAtRev(rev + 1); err != nil || got != text {
+			t.Fatalf("modified revision %d is %q, %v", rev+1, got, err)
+		}
+	}
+	for rev, text := range []string{"a", "b", "c"} {
+		if got, err := pf.TextAtRev(rev + 1); err != nil || got != text {
+			t.Fatalf("original revision %d is %q, %v", rev+1, got, err)
+		}
+	}
+
+	// A copy being modified directly gets its own texts, even while sharing the cache.
+	edited := pf
+	edited.Revs = edited.Revs[1:]
+	edited.Text, edited.Time, edited.Rev = "b", edited.Revs[0].Time, 2
+
+	for rev, text := range []string{"a", "b"} {
+		if got, err := edited.TextAtRev(rev + 1); err != nil || got != text {
+			t.Fatalf("edited revision %d is %q, %v", rev+1, got, err)
+		}
+	}
+	if got, err := pf.TextAtRev(3); err != nil || got != "c" {
+		t.Fatalf("original revision 3 is %q, %v", got, err)
+	}
+}
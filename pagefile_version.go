@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PageFileVersionAttribute is a key-value attribute within a PageFileVersion, e.g., "urlencoded=1".
+type PageFileVersionAttribute struct {
+	Key   string
+	Value string
+}
+
+// PageFileVersion describes a PageFile's version header, e.g., "pmwiki-2.2.130 ordered=1 urlencoded=1".
+type PageFileVersion struct {
+	// Release of PmWiki which wrote the page file, e.g., "pmwiki-2.2.130".
+	Release string
+
+	// Attributes following the release in their original order.
+	Attributes []PageFileVersionAttribute
+}
+
+// ParsePageFileVersion parses a version header's value into a PageFileVersion.
+func ParsePageFileVersion(version string) (pageFileVersion PageFileVersion) {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return
+	}
+
+	pageFileVersion.Release = fields[0]
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		attr := PageFileVersionAttribute{Key: kv[0]}
+		if len(kv) == 2 {
+			attr.Value = kv[1]
+		}
+		pageFileVersion.Attributes = append(pageFileVersion.Attributes, attr)
+	}
+
+	return
+}
+
+// VersionInfo parses this PageFile's Version header into a PageFileVersion.
+func (pageFile PageFile) VersionInfo() PageFileVersion {
+	return ParsePageFileVersion(pageFile.Version)
+}
+
+// String formats this PageFileVersion back to a version header's value.
+func (pageFileVersion PageFileVersion) String() string {
+	fields := []string{pageFileVersion.Release}
+	for _, attr := range pageFileVersion.Attributes {
+		if attr.Value == "" {
+			fields = append(fields, attr.Key)
+		} else {
+			fields = append(fields, attr.Key+"="+attr.Value)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// Attribute returns the value of an attribute and if it exists.
+func (pageFileVersion PageFileVersion) Attribute(key string) (value string, ok bool) {
+	for _, attr := range pageFileVersion.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// Ordered is true iff the page file is ordered, i.e., its revisions are sorted from the newest to the oldest.
+func (pageFileVersion PageFileVersion) Ordered() bool {
+	value, _ := pageFileVersion.Attribute("ordered")
+	return value == "1"
+}
+
+// URLEncoded is true iff the page file's values are URL encoded.
+func (pageFileVersion PageFileVersion) URLEncoded() bool {
+	value, _ := pageFileVersion.Attribute("urlencoded")
+	return value == "1"
+}
+
+// Newline returns the legacy newline encoding of pre-urlencoded page files or an empty string.
+//
+// Such page files written by PmWiki 1.x and early 2.x releases either have a "newline" attribute or, for PmWiki 0.x
+// releases, implicitly use the \262 byte.
+func (pageFileVersion PageFileVersion) Newline() string {
+	if newline, ok := pageFileVersion.Attribute("newline"); ok {
+		return newline
+	} else if strings.HasPrefix(pageFileVersion.Release, "pmwiki-0.") {
+		return "\262"
+	}
+	return ""
+}
+
+// releaseParts splits the Release into its leading numeric parts and the remaining pre-release suffix, e.g., [2 0]
+// and "beta33" for "pmwiki-2.0.beta33" or [2 2 0] and "beta1" for "pmwiki-2.2.0-beta1".
+func (pageFileVersion PageFileVersion) releaseParts() (numbers []int, suffix string) {
+	release := strings.TrimPrefix(pageFileVersion.Release, "pmwiki-")
+
+	parts := strings.Split(release, ".")
+	for i, part := range parts {
+		digits := part
+		if j := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' }); j >= 0 {
+			digits = part[:j]
+		}
+
+		number, err := strconv.Atoi(digits)
+		if err != nil {
+			suffix = strings.Join(parts[i:], ".")
+			break
+		}
+		numbers = append(numbers, number)
+
+		if len(digits) != len(part) {
+			suffix = strings.Join(append([]string{part[len(digits):]}, parts[i+1:]...), ".")
+			break
+		}
+	}
+
+	suffix = strings.TrimLeft(suffix, "-._")
+	return
+}
+
+// ReleaseNumbers returns the numeric parts of the Release, e.g., [2 2 130] for "pmwiki-2.2.130". Parsing stops at
+// the first non-numeric part, e.g., [2 3 0] for "pmwiki-2.3.0-beta1", which is returned by PreRelease.
+func (pageFileVersion PageFileVersion) ReleaseNumbers() []int {
+	numbers, _ := pageFileVersion.releaseParts()
+	return numbers
+}
+
+// PreRelease returns the pre-release part of the Release, e.g., "beta" and 33 for "pmwiki-2.0.beta33" or "beta" and 1
+// for "pmwiki-2.2.0-beta1". The ok flag is false for a final release.
+func (pageFileVersion PageFileVersion) PreRelease() (tag string, number int, ok bool) {
+	_, suffix := pageFileVersion.releaseParts()
+	if suffix == "" {
+		return "", 0, false
+	}
+
+	i := strings.IndexFunc(suffix, func(r rune) bool { return r >= '0' && r <= '9' })
+	if i < 0 {
+		return strings.ToLower(suffix), 0, true
+	}
+
+	tag = strings.ToLower(strings.TrimRight(suffix[:i], "-._"))
+	digits := suffix[i:]
+	if j := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); j >= 0 {
+		digits = digits[:j]
+	}
+	number, _ = strconv.Atoi(digits)
+	return tag, number, true
+}
+
+// preReleaseRanks orders the known pre-release tags. Unknown tags are ranked after them, ordered by their name.
+var preReleaseRanks = map[string]int{"devel": 1, "alpha": 2, "beta": 3, "pre": 4, "rc": 5}
+
+// Compare the releases of two PageFileVersions based on their ReleaseNumbers and PreRelease. A pre-release is older
+// than its final release, e.g., "pmwiki-2.0.beta55" is older than "pmwiki-2.0.0". The result is negative if this
+// release is older than the other one, zero if both are equal, and positive if this release is newer.
+func (pageFileVersion PageFileVersion) Compare(other PageFileVersion) int {
+	numbers, otherNumbers := pageFileVersion.ReleaseNumbers(), other.ReleaseNumbers()
+
+	for i := 0; i < len(numbers) || i < len(otherNumbers); i++ {
+		var number, otherNumber int
+		if i < len(numbers) {
+			number = numbers[i]
+		}
+		if i < len(otherNumbers) {
+			otherNumber = otherNumbers[i]
+		}
+
+		if number != otherNumber {
+			return number - otherNumber
+		}
+	}
+
+	tag, number, pre := pageFileVersion.PreRelease()
+	otherTag, otherNumber, otherPre := other.PreRelease()
+	switch {
+	case !pre && !otherPre:
+		return 0
+	case !pre:
+		return 1
+	case !otherPre:
+		return -1
+	}
+
+	rank, otherRank := preReleaseRanks[tag], preReleaseRanks[otherTag]
+	if rank == 0 {
+		rank = len(preReleaseRanks) + 1
+	}
+	if otherRank == 0 {
+		otherRank = len(preReleaseRanks) + 1
+	}
+
+	switch {
+	case rank != otherRank:
+		return rank - otherRank
+	case tag != otherTag:
+		return strings.Compare(tag, otherTag)
+	default:
+		return number - otherNumber
+	}
+}
+
+// Before is true iff this release is older than the other one's.
+func (pageFileVersion PageFileVersion) Before(other PageFileVersion) bool {
+	return pageFileVersion.Compare(other) < 0
+}
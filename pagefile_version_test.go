@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePageFileVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		release    string
+		numbers    []int
+		ordered    bool
+		urlencoded bool
+	}{
+		{"empty", "", "", nil, false, false},
+		{"release only", "pmwiki-2.1.0", "pmwiki-2.1.0", []int{2, 1, 0}, false, false},
+		{"urlencoded", "pmwiki-2.1.0 urlencoded=1", "pmwiki-2.1.0", []int{2, 1, 0}, false, true},
+		{"ordered", "pmwiki-2.2.130 ordered=1 urlencoded=1", "pmwiki-2.2.130", []int{2, 2, 130}, true, true},
+		{"beta", "pmwiki-2.3.0-beta1 ordered=1 urlencoded=1", "pmwiki-2.3.0-beta1", []int{2, 3, 0}, true, true},
+		{"legacy", "1.0.13 newline=\262", "1.0.13", []int{1, 0, 13}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version := ParsePageFileVersion(test.input)
+
+			if version.Release != test.release {
+				t.Fatalf("release %q != %q", version.Release, test.release)
+			}
+			if numbers := version.ReleaseNumbers(); !reflect.DeepEqual(numbers, test.numbers) {
+				t.Fatalf("release numbers %v != %v", numbers, test.numbers)
+			}
+			if version.Ordered() != test.ordered || version.URLEncoded() != test.urlencoded {
+				t.Fatalf("unexpected attributes %v", version.Attributes)
+			}
+			if s := version.String(); s != test.input {
+				t.Fatalf("string %q != %q", s, test.input)
+			}
+		})
+	}
+}
+
+func TestPageFileVersionAttribute(t *testing.T) {
+	version := ParsePageFileVersion("1.0.13 newline=\262 flag")
+
+	if value, ok := version.Attribute("newline"); !ok || value != "\262" {
+		t.Fatalf("newline attribute is %q, %t", value, ok)
+	}
+	if _, ok := version.Attribute("flag"); !ok {
+		t.Fatal("flag attribute is missing")
+	}
+	if _, ok := version.Attribute("urlencoded"); ok {
+		t.Fatal("urlencoded attribute exists")
+	}
+}
+
+func TestPageFileVersionNewline(t *testing.T) {
+	tests := []struct {
+		input   string
+		newline string
+	}{
+		{"pmwiki-2.2.130 ordered=1 urlencoded=1", ""},
+		{"pmwiki-1.0.13 newline=\262", "\262"},
+		{"pmwiki-0.6.20", "\262"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			if newline := ParsePageFileVersion(test.input).Newline(); newline != test.newline {
+				t.Fatalf("newline %q != %q", newline, test.newline)
+			}
+		})
+	}
+}
+
+func TestPageFileVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		result int
+	}{
+		{"pmwiki-2.2.130", "pmwiki-2.2.130 ordered=1 urlencoded=1", 0},
+		{"pmwiki-2.2.99", "pmwiki-2.2.130", -1},
+		{"pmwiki-2.2.130", "pmwiki-2.2.99", 1},
+		{"pmwiki-2.1.0", "pmwiki-2.1", 0},
+		{"pmwiki-2.1.27", "pmwiki-2.2.0-beta1", -1},
+		{"1.0.13", "pmwiki-2.0.0", -1},
+		{"pmwiki-2.0.beta33", "pmwiki-2.0.beta55", -1},
+		{"pmwiki-2.0.beta55", "pmwiki-2.0.0", -1},
+		{"pmwiki-2.0.beta33", "pmwiki-2.0.0", -1},
+		{"pmwiki-2.0.0", "pmwiki-2.0.beta55", 1},
+		{"pmwiki-2.2.0-beta1", "pmwiki-2.2.0", -1},
+		{"pmwiki-2.2.0-beta65", "pmwiki-2.2.0-beta1", 1},
+		{"pmwiki-2.2.0-beta65", "pmwiki-2.1.27", 1},
+		{"pmwiki-2.0.devel3", "pmwiki-2.0.beta1", -1},
+		{"pmwiki-2.3.0-rc1", "pmwiki-2.3.0-beta2", 1},
+		{"pmwiki-2.2.0-beta1", "pmwiki-2.2.0-beta1 ordered=1", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			a, b := ParsePageFileVersion(test.a), ParsePageFileVersion(test.b)
+
+			result := a.Compare(b)
+			if (result < 0 && test.result >= 0) || (result == 0 && test.result != 0) || (result > 0 && test.result <= 0) {
+				t.Fatalf("comparison resulted in %d, expected %d", result, test.result)
+			}
+			if a.Before(b) != (test.result < 0) {
+				t.Fatalf("before is %t", a.Before(b))
+			}
+		})
+	}
+}
+
+func TestPageFileVersionPreRelease(t *testing.T) {
+	tests := []struct {
+		input  string
+		tag    string
+		number int
+		ok     bool
+	}{
+		{"pmwiki-2.2.130", "", 0, false},
+		{"pmwiki-2.0.beta33", "beta", 33, true},
+		{"pmwiki-2.2.0-beta1", "beta", 1, true},
+		{"pmwiki-2.0.devel3", "devel", 3, true},
+		{"pmwiki-2.3.0-RC", "rc", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			tag, number, ok := ParsePageFileVersion(test.input).PreRelease()
+			if tag != test.tag || number != test.number || ok != test.ok {
+				t.Fatalf("pre-release %q %d %t, expected %q %d %t", tag, number, ok, test.tag, test.number, test.ok)
+			}
+		})
+	}
+}
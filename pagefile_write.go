@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pageFileRelease is the PmWiki release to be written, if a PageFile has no version.
+const pageFileRelease = "pmwiki-2.2.130"
+
+// pageFileEncoder encodes values just like PmWiki does for urlencoded page files.
+var pageFileEncoder = strings.NewReplacer("%", "%25", "\n", "%0a", "<", "%3c")
+
+// name of this PageFileField, its key joined with its key options.
+func (field PageFileField) name() string {
+	return strings.Join(append([]string{field.Key}, field.Opts...), ":")
+}
+
+// timestamp of this PageFileField, its first key option or zero.
+func (field PageFileField) timestamp() int64 {
+	if len(field.Opts) == 0 {
+		return 0
+	}
+	unix, _ := strconv.ParseInt(field.Opts[0], 10, 64)
+	return unix
+}
+
+// less orders PageFileFields as PmWiki's CmpPageAttr does: fields without a timestamp first, followed by the newest
+// revisions. Fields of the same timestamp are ordered by their name.
+func (field PageFileField) less(other PageFileField) bool {
+	if unix, otherUnix := field.timestamp(), other.timestamp(); unix != otherUnix {
+		if unix == 0 || otherUnix == 0 {
+			return unix < otherUnix
+		}
+		return unix > otherUnix
+	}
+	return field.name() < other.name()
+}
+
+// pageFileUnix formats a time as a Unix timestamp.
+func pageFileUnix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// pageFileWriteVersion creates the version value, which always indicates an ordered and urlencoded page file. Thus,
+// a legacy newline encoding is dropped, as PmWiki does when saving a legacy page file.
+func pageFileWriteVersion(version PageFileVersion) string {
+	out := PageFileVersion{Release: version.Release}
+	if out.Release == "" {
+		out.Release = pageFileRelease
+	}
+
+	for _, attr := range version.Attributes {
+		if attr.Key != "ordered" && attr.Key != "urlencoded" && attr.Key != "newline" {
+			out.Attributes = append(out.Attributes, attr)
+		}
+	}
+	out.Attributes = append(out.Attributes,
+		PageFileVersionAttribute{Key: "ordered", Value: "1"},
+		PageFileVersionAttribute{Key: "urlencoded", Value: "1"})
+
+	return out.String()
+}
+
+// fields of this PageFile to be written, excluding the version.
+//
+// Some fields are always written, even when empty, because PmWiki does so as well. Unknown fields are sorted in
+// between, while keeping the original order of fields with the same name. Diffs deferred by WithLazyDiffs are written
+// as they were stored, see RawDiff.
+func (pageFile PageFile) fields() (fields []PageFileField, err error) {
+	fields = append(fields,
+		PageFileField{Key: "author", Value: pageFile.Author},
+		PageFileField{Key: "name", Value: pageFile.Name},
+		PageFileField{Key: "text", Value: pageFile.Text})
+
+	if len(pageFile.Host) > 0 {
+		fields = append(fields, PageFileField{Key: "host", Value: pageFile.Host.String()})
+	}
+	if pageFile.Rev != 0 {
+		fields = append(fields, PageFileField{Key: "rev", Value: strconv.Itoa(pageFile.Rev)})
+	}
+	if pageFile.Time != (time.Time{}) {
+		fields = append(fields, PageFileField{Key: "time", Value: pageFileUnix(pageFile.Time)})
+	}
+
+	if pageFile.CreateTime != (time.Time{}) {
+		fields = append(fields, PageFileField{Key: "ctime", Value: pageFileUnix(pageFile.CreateTime)})
+	}
+	if len(pageFile.Targets) > 0 || pageFile.present["targets"] {
+		fields = append(fields, PageFileField{Key: "targets", Value: strings.Join(pageFile.Targets, ",")})
+	}
+	for _, key := range pageFileStringFields {
+		if value := *pageFile.stringField(key); value != "" || pageFile.present[key] {
+			fields = append(fields, PageFileField{Key: key, Value: value})
+		}
+	}
+
+	// Revisions within the same second share their author, host, and csum fields, taken from the newest one.
+	revTimes := make(map[time.Time]bool)
+	for _, rev := range pageFile.Revs {
+		unix := pageFileUnix(rev.Time)
+
+		if !revTimes[rev.Time] {
+			revTimes[rev.Time] = true
+
+			fields = append(fields, PageFileField{Key: "author", Opts: []string{unix}, Value: rev.Author})
+
+			if len(rev.Host) > 0 {
+				fields = append(fields, PageFileField{Key: "host", Opts: []string{unix}, Value: rev.Host.String()})
+			}
+			if rev.Summary != "" {
+				fields = append(fields, PageFileField{Key: "csum", Opts: []string{unix}, Value: rev.Summary})
+			}
+		}
+		if rev.DiffAgainst != (time.Time{}) {
+			diffClass := ""
+			if rev.Minor {
+				diffClass = "minor"
+			}
+
+			diff, err := rev.RawDiff()
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, PageFileField{
+				Key:   "diff",
+				Opts:  []string{unix, pageFileUnix(rev.DiffAgainst), diffClass},
+				Value: diff,
+			})
+		}
+	}
+
+	fields = append(fields, pageFile.Unknown...)
+
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].less(fields[j]) })
+	return fields, nil
+}
+
+// WritePageFile writes a PageFile in PmWiki's PageFileFormat, which can be read by both PmWiki and ParsePageFile.
+//
+// The output is always an ordered and urlencoded page file. Fields are sorted in the same order as PmWiki sorts them.
+// The values are written in UTF-8, unless a charset conversion is enabled by either WithCharset or WithAutoCharset.
+// A PageFile converted by ParsePageFile is converted back by default, following its possibly changed Charset field.
+func WritePageFile(w io.Writer, pageFile PageFile, opts ...PageFileOption) error {
+	if err := pageFile.checkContent(); err != nil {
+		return err
+	}
+
+	if charset := newPageFileOptions(opts).pageFileCharset(pageFile); charset != "" {
+		if _, encoder, err := charsetConverters(charset); err != nil {
+			return err
+		} else if encoder != nil {
+			if pf, err := pageFile.convert(encoder); err != nil {
+				return fmt.Errorf("encoding %s errored, %w", charset, err)
+			} else {
+				pageFile = pf
+			}
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+
+	if _, err := fmt.Fprintf(writer, "version=%s\n", pageFileWriteVersion(pageFile.VersionInfo())); err != nil {
+		return err
+	}
+
+	fields, err := pageFile.fields()
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if _, err := fmt.Fprintf(writer, "%s=%s\n", field.name(), pageFileEncoder.Replace(field.Value)); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
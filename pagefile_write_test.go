@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePageFileRoundTrip(t *testing.T) {
+	input1 := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\nauthor=\nname=Main.Test\ntext=\n"
+
+	input2 := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\nauthor=user\nhost=2001:db8::1\nname=Main.Test\nrev=42\n" +
+		"text=%0ahello%0aworld\ntime=1603541891\n"
+
+	input3 := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\nauthor=oxzi\nhost=fe80::1\nname=Main.Test\nrev=2\n" +
+		"text=Hello %3cworld>%0a100%25 + more\ntime=1603451578\n" +
+		"author:1603451578=oxzi\n" +
+		"csum:1603451578=%3cgreetings>\n" +
+		"diff:1603451578:1603450377:minor=1,2c1%0a%3c Hello %3cworld>%0a%3c 100%25 + more%0a\\ No newline at end of file%0a---%0a> Hello world%0a\\ No newline at end of file%0a\n" +
+		"host:1603451578=fe80::1\n" +
+		"author:1603450377=\n" +
+		"diff:1603450377:1603450377:=1d0%0a%3c Hello world%0a\\ No newline at end of file%0a\n" +
+		"host:1603450377=172.23.42.128\n"
+
+	input4 := "version=pmwiki-2.2.106 ordered=1 urlencoded=1\nagent=Mozilla/5.0\nauthor=oxzi\ncsum=\nctime=1603390737\n" +
+		"host=fe80::1\nname=Main.Test\npasswdread=@lock\nrev=1\ntargets=Main.Foo,Main.Bar\ntext=foo\ntime=1603390737\n" +
+		"title=Test %3cpage>\n" +
+		"author:1603390737=oxzi\n" +
+		"cookbook:1603390737:extra=custom\n" +
+		"diff:1603390737:1603390737:=\n" +
+		"host:1603390737=fe80::1\n"
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty page", input1},
+		{"all main fields", input2},
+		{"revisions", input3},
+		{"metadata and unknown fields", input4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pf, err := ParsePageFile(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out strings.Builder
+			if err := WritePageFile(&out, pf); err != nil {
+				t.Fatal(err)
+			} else if out.String() != test.input {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.input, out.String())
+			}
+
+			if pf2, err := ParsePageFile(strings.NewReader(out.String())); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(pf, pf2) {
+				t.Fatalf("%v != %v", pf, pf2)
+			}
+		})
+	}
+}
+
+func TestWritePageFileVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		output  string
+	}{
+		{"empty", "", "pmwiki-2.2.130 ordered=1 urlencoded=1"},
+		{"unordered", "pmwiki-2.1.0 urlencoded=1", "pmwiki-2.1.0 ordered=1 urlencoded=1"},
+		{"not urlencoded", "pmwiki-2.1.0", "pmwiki-2.1.0 ordered=1 urlencoded=1"},
+		{"unchanged", "pmwiki-2.2.106 ordered=1 urlencoded=1", "pmwiki-2.2.106 ordered=1 urlencoded=1"},
+		{"legacy newline", "pmwiki-1.0.13 newline=\262", "pmwiki-1.0.13 ordered=1 urlencoded=1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := WritePageFile(&out, PageFile{Version: test.version}); err != nil {
+				t.Fatal(err)
+			} else if line := strings.SplitN(out.String(), "\n", 2)[0]; line != "version="+test.output {
+				t.Fatalf("unexpected version line %q", line)
+			}
+		})
+	}
+}
+
+func TestWritePageFileOrder(t *testing.T) {
+	pf := PageFile{
+		Name: "Main.Test",
+		Time: time.Unix(30, 0).UTC(),
+		Text: "c",
+		Rev:  3,
+		Revs: []PageFileRevision{
+			{Time: time.Unix(10, 0).UTC(), Host: net.ParseIP("::1"), DiffAgainst: time.Unix(10, 0).UTC()},
+			{Time: time.Unix(30, 0).UTC(), Author: "foo", DiffAgainst: time.Unix(20, 0).UTC()},
+			{Time: time.Unix(20, 0).UTC(), DiffAgainst: time.Unix(10, 0).UTC()},
+		},
+	}
+
+	expected := "version=pmwiki-2.2.130 ordered=1 urlencoded=1\nauthor=\nname=Main.Test\nrev=3\ntext=c\ntime=30\n" +
+		"author:30=foo\ndiff:30:20:=\n" +
+		"author:20=\ndiff:20:10:=\n" +
+		"author:10=\ndiff:10:10:=\nhost:10=::1\n"
+
+	var out strings.Builder
+	if err := WritePageFile(&out, pf); err != nil {
+		t.Fatal(err)
+	} else if out.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestWritePageFileLegacy(t *testing.T) {
+	input := "version=pmwiki-1.0.13 newline=\262\nname=Main.Test\ntext=foo\262<bar>\262\ntime=20\n" +
+		"diff:20:10:=2c2\262< <bar>\262---\262> buz\262\n"
+	expected := "version=pmwiki-1.0.13 ordered=1 urlencoded=1\nauthor=\nname=Main.Test\ntext=foo%0a%3cbar>%0a\ntime=20\n" +
+		"author:20=\ndiff:20:10:=2c2%0a%3c %3cbar>%0a---%0a> buz%0a\n"
+
+	pf, err := ParsePageFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := WritePageFile(&out, pf); err != nil {
+		t.Fatal(err)
+	} else if out.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
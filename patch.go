@@ -31,30 +31,43 @@ type patchAction struct {
 	startLine     int
 	additionLines []string
 	deletionLines []string
+
+	// additionNoNewline and deletionNoNewline are set if the last line had no trailing newline.
+	additionNoNewline bool
+	deletionNoNewline bool
 }
 
-// apply this patchAction. Will be called from Patch.Apply.
-func (patchAction patchAction) apply(scanner *bufio.Scanner, out io.Writer) (consumed int, err error) {
+// applyFuzzFunc is called for the consumed-th deletion line only matching its input after trimming whitespaces.
+type applyFuzzFunc func(consumed int, expected, input string)
+
+// apply this patchAction. Will be called from Patch.Apply. The fuzz function is optional.
+func (patchAction patchAction) apply(in *patchInput, out *patchOutput, fuzz applyFuzzFunc) (consumed int, err error) {
 	if patchAction.mode == deletion || patchAction.mode == change {
-		for consumed = 0; consumed < len(patchAction.deletionLines) && scanner.Scan(); consumed++ {
-			// Sometimes PmWiki truncates whitespaces from patches, because oh̨ m͞y gǫd ͜p̀mwi͝ki s̡̧͝t͏a̢̧̛h̀p̕ ͝w͏̸̵h͢a͢͞t̴̨̀ a̧̧re ̢͠y̨͠o̧͏ư̴̴ d͡o̴i͜ng̕?̸̕͞!̡͠!
-			input := scanner.Text()
+		for consumed = 0; consumed < len(patchAction.deletionLines); consumed++ {
+			input, _, inErr := in.next()
+			if inErr == io.EOF {
+				break
+			} else if inErr != nil {
+				err = inErr
+				return
+			}
+
+			// Sometimes PmWiki truncates whitespaces from patches, because oh̨ m͞y gǫd ͜p̀mwi͝ki s̡̧͝t͏a̢̧̛h̀p̕ ͝w͏̸̵h͢a͢͞t̴̨̀ a̧̧re ̢͠y̨͠o̧͏ư̴̴ d͡o̴i͜ng̕?̸̕͞!̡͠!
 			expected := patchAction.deletionLines[consumed]
 
 			if input != expected && input != strings.TrimSpace(expected) {
 				err = fmt.Errorf("patch:%d expected \"%s\", got \"%s\"", consumed, patchAction.deletionLines[consumed], input)
 				return
+			} else if input != expected && fuzz != nil {
+				fuzz(consumed, expected, input)
 			}
 		}
-		if scannerErr := scanner.Err(); scannerErr != nil {
-			err = scannerErr
-			return
-		}
 	}
 
 	if patchAction.mode == addition || patchAction.mode == change {
-		for _, line := range patchAction.additionLines {
-			if _, err = fmt.Fprint(out, line, "\n"); err != nil {
+		for i, line := range patchAction.additionLines {
+			newline := !patchAction.additionNoNewline || i < len(patchAction.additionLines)-1
+			if err = out.writeLine(line, newline); err != nil {
 				return
 			}
 		}
@@ -63,18 +76,174 @@ func (patchAction patchAction) apply(scanner *bufio.Scanner, out io.Writer) (con
 	return
 }
 
+// patchInput reads the lines of a Patch's input.
+type patchInput struct {
+	reader *bufio.Reader
+}
+
+// next line without its trailing newline. The newline flag reports if the line was terminated by a newline, which is
+// only missing for the last line. An io.EOF is returned if there are no more lines.
+func (in *patchInput) next() (line string, newline bool, err error) {
+	line, err = in.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return line[:len(line)-1], true, nil
+}
+
+// patchOutput writes the lines of a Patch's output.
+type patchOutput struct {
+	writer       io.Writer
+	unterminated bool
+}
+
+// writeLine to the output. If the previous line was written without a newline, it will be terminated first.
+func (out *patchOutput) writeLine(line string, newline bool) (err error) {
+	if out.unterminated {
+		if _, err = io.WriteString(out.writer, "\n"); err != nil {
+			return
+		}
+	}
+
+	if _, err = io.WriteString(out.writer, line); err != nil {
+		return
+	}
+	if newline {
+		_, err = io.WriteString(out.writer, "\n")
+	}
+
+	out.unterminated = !newline
+	return
+}
+
 // Patch is the difference between two revisions stored as a `diff`.
 type Patch []patchAction
 
+// applyString applies this Patch to a string, as Apply does for streams.
+func (patch Patch) applyString(text string) (string, error) {
+	var out strings.Builder
+	if err := patch.Apply(strings.NewReader(text), &out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Invert this Patch into a new Patch, which transforms the patched output back into the original input.
+//
+// As PmWiki stores reverse diffs from the newer to the older revision, an inverted Patch describes an edit in its
+// natural direction, e.g., to replay a history forwards.
+func (patch Patch) Invert() (out Patch) {
+	offset := 0
+	for _, patchAction := range patch {
+		inverted := patchAction
+		inverted.additionLines, inverted.deletionLines = patchAction.deletionLines, patchAction.additionLines
+		inverted.additionNoNewline, inverted.deletionNoNewline = patchAction.deletionNoNewline, patchAction.additionNoNewline
+
+		switch patchAction.mode {
+		case addition:
+			inverted.mode = deletion
+			inverted.startLine = patchAction.startLine + offset + 1
+		case deletion:
+			inverted.mode = addition
+			inverted.startLine = patchAction.startLine + offset - 1
+		case change:
+			inverted.startLine = patchAction.startLine + offset
+		}
+
+		out = append(out, inverted)
+		offset += len(patchAction.additionLines) - len(patchAction.deletionLines)
+	}
+	return
+}
+
+// applyOptions are the settings altered by ApplyOptions.
+type applyOptions struct {
+	strict bool
+	report *ApplyReport
+}
+
+// ApplyOption alters the behavior of Patch.Apply.
+type ApplyOption func(*applyOptions)
+
+// WithStrictApply rejects a Patch whose hunks exceed the input instead of ignoring them.
+func WithStrictApply() ApplyOption {
+	return func(options *applyOptions) {
+		options.strict = true
+	}
+}
+
+// ApplyFuzz is a deletion line which only matched the input after trimming its whitespaces, as PmWiki sometimes
+// truncates them within its diffs.
+type ApplyFuzz struct {
+	// Hunk number within the Patch and Line number within the input, both starting at one.
+	Hunk int
+	Line int
+
+	Expected string
+	Actual   string
+}
+
+// ApplyReport lists all fuzzy matches of Patch.Apply, see WithApplyReport.
+type ApplyReport struct {
+	Fuzz []ApplyFuzz
+}
+
+// Fuzzy is true iff at least one line was matched fuzzily.
+func (report ApplyReport) Fuzzy() bool {
+	return len(report.Fuzz) > 0
+}
+
+// WithApplyReport stores an ApplyReport of the applied Patch in the given report, which is reset first.
+func WithApplyReport(report *ApplyReport) ApplyOption {
+	return func(options *applyOptions) {
+		options.report = report
+	}
+}
+
 // Apply this Patch to an input stream and write the patched result back to an output stream.
-func (patch Patch) Apply(in io.Reader, out io.Writer) error {
-	scanner := bufio.NewScanner(in)
+//
+// Lines are written back with their original line ending. Thus, a missing newline at the end of the input or a
+// "No newline at end of file" marker within the Patch results in an output without a trailing newline.
+//
+// By default, hunks exceeding the input are partially applied or ignored, which can be changed by WithStrictApply.
+// Deletion lines differing from the input only by surrounding whitespaces are accepted, but can be reported by
+// WithApplyReport.
+func (patch Patch) Apply(in io.Reader, out io.Writer, opts ...ApplyOption) error {
+	var options applyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	input := &patchInput{reader: bufio.NewReader(in)}
+	output := &patchOutput{writer: out}
+
+	var fuzz applyFuzzFunc
+	var fuzzHunk, fuzzLine int
+	if options.report != nil {
+		*options.report = ApplyReport{}
+		fuzz = func(consumed int, expected, input string) {
+			options.report.Fuzz = append(options.report.Fuzz, ApplyFuzz{
+				Hunk:     fuzzHunk,
+				Line:     fuzzLine + consumed,
+				Expected: expected,
+				Actual:   input,
+			})
+		}
+	}
 
 	for patchNo, line := 0, 0; ; {
+		fuzzHunk, fuzzLine = patchNo+1, line
+
 		// Deletion / Change first
 		if patchNo < len(patch) && patch[patchNo].startLine == line && (patch[patchNo].mode == deletion || patch[patchNo].mode == change) {
-			if consumed, err := patch[patchNo].apply(scanner, out); err != nil {
-				return err
+			if consumed, err := patch[patchNo].apply(input, output, fuzz); err != nil {
+				return fmt.Errorf("hunk %d at line %d, %w", patchNo+1, line, err)
+			} else if options.strict && consumed < len(patch[patchNo].deletionLines) {
+				return fmt.Errorf("hunk %d at line %d, input ended after %d of %d deleted lines",
+					patchNo+1, line, consumed, len(patch[patchNo].deletionLines))
 			} else {
 				patchNo++
 				line += consumed
@@ -83,17 +252,23 @@ func (patch Patch) Apply(in io.Reader, out io.Writer) error {
 
 		// Consume a line, unless we have not started reading, e.g., for an addition patch starting at line zero
 		if line > 0 {
-			if !scanner.Scan() {
-				return scanner.Err()
-			} else if _, err := fmt.Fprint(out, scanner.Text(), "\n"); err != nil {
+			if text, newline, err := input.next(); err == io.EOF {
+				if options.strict && patchNo < len(patch) {
+					return fmt.Errorf("hunk %d at line %d exceeds the input of %d lines",
+						patchNo+1, patch[patchNo].startLine, line-1)
+				}
+				return nil
+			} else if err != nil {
+				return err
+			} else if err := output.writeLine(text, newline); err != nil {
 				return err
 			}
 		}
 
 		// Addition second
 		if patchNo < len(patch) && patch[patchNo].startLine == line && patch[patchNo].mode == addition {
-			if _, err := patch[patchNo].apply(scanner, out); err != nil {
-				return err
+			if _, err := patch[patchNo].apply(input, output, nil); err != nil {
+				return fmt.Errorf("hunk %d at line %d, %w", patchNo+1, line, err)
 			} else {
 				patchNo++
 			}
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"strings"
+)
+
+// diffLine is a single line of a text to be compared.
+type diffLine struct {
+	text      string
+	noNewline bool
+}
+
+// diffSplit splits a text into its lines, as PmWiki's PHPDiff does. A last line without a trailing newline is marked
+// as such; thus, it differs from the same line being terminated.
+func diffSplit(text string) []diffLine {
+	parts := strings.Split(text, "\n")
+	last := parts[len(parts)-1]
+	parts = parts[:len(parts)-1]
+
+	lines := make([]diffLine, len(parts), len(parts)+1)
+	for i, part := range parts {
+		lines[i].text = part
+	}
+	if last != "" {
+		lines = append(lines, diffLine{text: last, noNewline: true})
+	}
+
+	return lines
+}
+
+// diffMatch is a pair of equal line indices within two texts.
+type diffMatch struct {
+	x, y int
+}
+
+// diffMyers calculates the matching lines of a longest common subsequence based on Eugene W. Myers' "An O(ND)
+// Difference Algorithm and Its Variations". The matches are returned in ascending order.
+//
+// The linear space refinement of the paper is used: the middle snake of the shortest edit script is searched from both
+// ends and the remaining parts before and after it are solved recursively. Thus, only O(N+M) space is required.
+func diffMyers(a, b []diffLine) (matches []diffMatch) {
+	maxD := (len(a) + len(b) + 1) / 2
+	differ := &diffMyersState{
+		a:  a,
+		b:  b,
+		vf: make([]int, 2*maxD+3),
+		vb: make([]int, 2*maxD+3),
+	}
+	differ.compare(0, len(a), 0, len(b))
+	return differ.matches
+}
+
+// diffMyersState is the state of diffMyers, shared by its recursive steps.
+type diffMyersState struct {
+	a, b []diffLine
+
+	// vf and vb hold the furthest reaching x coordinate for each diagonal k of the forward and the backward search.
+	// They are reused by each middleSnake search.
+	vf, vb []int
+
+	matches []diffMatch
+}
+
+// compare the lines a[x0:x1] and b[y0:y1], appending their matches in ascending order.
+func (differ *diffMyersState) compare(x0, x1, y0, y1 int) {
+	for x0 < x1 && y0 < y1 && differ.a[x0] == differ.b[y0] {
+		differ.matches = append(differ.matches, diffMatch{x0, y0})
+		x0, y0 = x0+1, y0+1
+	}
+
+	suffix := 0
+	for x0 < x1-suffix && y0 < y1-suffix && differ.a[x1-1-suffix] == differ.b[y1-1-suffix] {
+		suffix++
+	}
+	x1, y1 = x1-suffix, y1-suffix
+
+	// Without common lines at both ends, at least two edits are left for non-empty parts. Thus, the middle snake
+	// splits them into two strictly smaller parts. Otherwise, which is not expected, the parts are just replaced.
+	if x0 < x1 && y0 < y1 {
+		if x, y, u, v, ok := differ.middleSnake(x0, x1, y0, y1); ok {
+			differ.compare(x0, x, y0, y)
+			for ; x < u; x, y = x+1, y+1 {
+				differ.matches = append(differ.matches, diffMatch{x, y})
+			}
+			differ.compare(u, x1, v, y1)
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		differ.matches = append(differ.matches, diffMatch{x1 + i, y1 + i})
+	}
+}
+
+// middleSnake finds the middle snake from (x, y) to (u, v) of a shortest edit script between a[x0:x1] and b[y0:y1].
+//
+// The backward search operates on reversed coordinates, counting from the parts' ends.
+func (differ *diffMyersState) middleSnake(x0, x1, y0, y1 int) (x, y, u, v int, ok bool) {
+	a, b := differ.a[x0:x1], differ.b[y0:y1]
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	vf, vb := differ.vf, differ.vb
+	vf[offset+1], vb[offset+1] = 0, 0
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			vf[offset+k] = x
+
+			if backK := delta - k; odd && backK >= -(d-1) && backK <= d-1 && x+vb[offset+backK] >= n {
+				return x0 + startX, y0 + startY, x0 + x, y0 + y, true
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x, y = x+1, y+1
+			}
+			vb[offset+k] = x
+
+			if forwardK := delta - k; !odd && forwardK >= -d && forwardK <= d && x+vf[offset+forwardK] >= n {
+				return x0 + n - x, y0 + m - y, x0 + n - startX, y0 + m - startY, true
+			}
+		}
+	}
+
+	return
+}
+
+// diffAction creates a patchAction, replacing the deletion lines starting after line x by the addition lines.
+func diffAction(x int, deletionLines, additionLines []diffLine) (patchAction patchAction) {
+	switch {
+	case len(deletionLines) == 0:
+		patchAction.mode = addition
+		patchAction.startLine = x
+	case len(additionLines) == 0:
+		patchAction.mode = deletion
+		patchAction.startLine = x + 1
+	default:
+		patchAction.mode = change
+		patchAction.startLine = x + 1
+	}
+
+	for _, line := range deletionLines {
+		patchAction.deletionLines = append(patchAction.deletionLines, line.text)
+		patchAction.deletionNoNewline = line.noNewline
+	}
+	for _, line := range additionLines {
+		patchAction.additionLines = append(patchAction.additionLines, line.text)
+		patchAction.additionNoNewline = line.noNewline
+	}
+
+	return
+}
+
+// Diff calculates the Patch to transform the old text into the new text.
+//
+// The Patch is line based and has the same representation as PmWiki's own diffs. Keep in mind that PmWiki stores
+// reverse diffs within a PageFile, which transform a revision's text into its predecessor's text.
+func Diff(oldText, newText string) (patch Patch) {
+	a, b := diffSplit(oldText), diffSplit(newText)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	matches := diffMyers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	matches = append(matches, diffMatch{len(a) - suffix - prefix, len(b) - suffix - prefix})
+
+	x, y := prefix, prefix
+	for _, match := range matches {
+		matchX, matchY := prefix+match.x, prefix+match.y
+		if matchX > x || matchY > y {
+			patch = append(patch, diffAction(x, a[x:matchX], b[y:matchY]))
+		}
+		x, y = matchX+1, matchY+1
+	}
+
+	return
+}
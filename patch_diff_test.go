@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDiffApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+	}{
+		{"empty", "", ""},
+		{"equal", "foo\nbar", "foo\nbar"},
+		{"creation", "", "foo\nbar"},
+		{"deletion", "foo\nbar", ""},
+		{"append line", "foo\nbar", "foo\nbar\nbuz"},
+		{"prepend line", "foo\nbar", "buz\nfoo\nbar"},
+		{"change line", "foo\nbar\nbuz", "foo\nqux\nbuz"},
+		{"trailing newline added", "foo\nbar", "foo\nbar\n"},
+		{"trailing newline removed", "foo\nbar\n", "foo\nbar"},
+		{"empty lines", "\n\n\nfoo\n\n", "\nfoo\n\n\n"},
+		{"Wikipedia example",
+			"This part of the\ndocument has stayed the\nsame from version to\nversion.  It shouldn't\n" +
+				"be shown if it doesn't\nchange.  Otherwise, that\nwould not be helping to\ncompress the size of the\n" +
+				"changes.\n\nThis paragraph contains\ntext that is outdated.\nIt will be deleted in the\n" +
+				"near future.\n\nIt is important to spell\ncheck this dokument. On\nthe other hand, a\n" +
+				"misspelled word isn't\nthe end of the world.\nNothing in the rest of\nthis paragraph needs to\n" +
+				"be changed. Things can\nbe added after it.\n",
+			"This is an important\nnotice! It should\ntherefore be located at\nthe beginning of this\n" +
+				"document!\n\nThis part of the\ndocument has stayed the\nsame from version to\nversion.  It shouldn't\n" +
+				"be shown if it doesn't\nchange.  Otherwise, that\nwould not be helping to\ncompress the size of the\n" +
+				"changes.\n\nIt is important to spell\ncheck this document. On\nthe other hand, a\nmisspelled word isn't\n" +
+				"the end of the world.\nNothing in the rest of\nthis paragraph needs to\nbe changed. Things can\n" +
+				"be added after it.\n\nThis paragraph contains\nimportant new additions\nto this document.\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := Diff(test.oldText, test.newText)
+
+			var out strings.Builder
+			if err := patch.Apply(strings.NewReader(test.oldText), &out); err != nil {
+				t.Fatal(err)
+			} else if out.String() != test.newText {
+				t.Fatalf("expected %q, got %q", test.newText, out.String())
+			}
+
+			if reparsed, err := parsePatch(patch.String()); err != nil {
+				t.Fatal(err)
+			} else if reparsed.String() != patch.String() {
+				t.Fatalf("reparsed patch differs: %q != %q", reparsed.String(), patch.String())
+			}
+		})
+	}
+}
+
+func TestDiffString(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		diff    string
+	}{
+		{"equal", "foo", "foo", ""},
+		{"creation", "", "foo\nbar", "0a1,2\n> foo\n> bar\n\\ No newline at end of file\n"},
+		{"deletion", "foo\nbar", "", "1,2d0\n< foo\n< bar\n\\ No newline at end of file\n"},
+		{"change", "a\nb\nc", "a\nB\nc", "2c2\n< b\n---\n> B\n"},
+		{"last line", "a\nb", "a\nB", "2c2\n< b\n\\ No newline at end of file\n---\n> B\n\\ No newline at end of file\n"},
+		{"Wikipedia example",
+			"a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\no\np\nq\nr\ns\nt\nu\nv\nw\nx\n",
+			"1\n2\n3\n4\n5\n6\na\nb\nc\nd\ne\nf\ng\nh\ni\nj\np\nQ\nr\ns\nt\nu\nv\nw\nx\ny\nz\n",
+			"0a1,6\n> 1\n> 2\n> 3\n> 4\n> 5\n> 6\n11,15d16\n< k\n< l\n< m\n< n\n< o\n17c18\n< q\n---\n> Q\n24a26,27\n> y\n> z\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := Diff(test.oldText, test.newText).String(); diff != test.diff {
+				t.Fatalf("expected %q, got %q", test.diff, diff)
+			}
+		})
+	}
+}
+
+func TestDiffApplyRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(23))
+	words := []string{"", "foo", "bar", "buz", "qux"}
+
+	randomText := func() string {
+		lines := make([]string, random.Intn(20))
+		for i := range lines {
+			lines[i] = words[random.Intn(len(words))]
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for i := 0; i < 1000; i++ {
+		oldText, newText := randomText(), randomText()
+
+		var out strings.Builder
+		if err := Diff(oldText, newText).Apply(strings.NewReader(oldText), &out); err != nil {
+			t.Fatal(err)
+		} else if out.String() != newText {
+			t.Fatalf("patching %q to %q resulted in %q", oldText, newText, out.String())
+		}
+	}
+}
+
+// diffRewriteTexts returns two texts of the given lines, having no line in common.
+func diffRewriteTexts(lines int) (oldText, newText string) {
+	var oldBuilder, newBuilder strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&oldBuilder, "old line %d\n", i)
+		fmt.Fprintf(&newBuilder, "new line %d\n", i)
+	}
+	return oldBuilder.String(), newBuilder.String()
+}
+
+func TestDiffRewrite(t *testing.T) {
+	oldText, newText := diffRewriteTexts(3000)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	patch := Diff(oldText, newText)
+	runtime.ReadMemStats(&after)
+
+	// The quadratic trace of the former implementation required about 290 MB.
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Fatalf("diff allocated %d bytes", alloc)
+	}
+
+	if len(patch) != 1 || patch[0].mode != change {
+		t.Fatalf("expected a single change, got %d actions", len(patch))
+	} else if text, err := patch.applyString(oldText); err != nil {
+		t.Fatal(err)
+	} else if text != newText {
+		t.Fatal("applied diff differs from the new text")
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	for _, lines := range []int{100, 1000, 6000} {
+		oldText, newText := diffRewriteTexts(lines)
+
+		b.Run(fmt.Sprintf("rewrite,lines=%d", lines), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				Diff(oldText, newText)
+			}
+		})
+	}
+}
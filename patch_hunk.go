@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HunkMode describes the change of a Hunk.
+type HunkMode int
+
+const (
+	_ HunkMode = iota
+
+	// HunkAddition of lines, "a" within a diff.
+	HunkAddition
+	// HunkDeletion of lines, "d" within a diff.
+	HunkDeletion
+	// HunkChange of lines, "c" within a diff; a combined deletion and addition.
+	HunkChange
+)
+
+func (mode HunkMode) String() string {
+	switch mode {
+	case HunkAddition:
+		return "a"
+	case HunkDeletion:
+		return "d"
+	case HunkChange:
+		return "c"
+	default:
+		return fmt.Sprintf("HunkMode(%d)", int(mode))
+	}
+}
+
+// Hunk is a read-only view of one change within a Patch, e.g., "2,3c2" with its lines.
+type Hunk struct {
+	action patchAction
+
+	// offset is the line difference caused by all previous Hunks of the Patch.
+	offset int
+}
+
+// Mode of this Hunk. It is the invalid HunkMode(0) for an invalid Hunk of a zero-valued patchAction, which is also
+// skipped by String.
+func (hunk Hunk) Mode() HunkMode {
+	switch hunk.action.mode {
+	case addition:
+		return HunkAddition
+	case deletion:
+		return HunkDeletion
+	case change:
+		return HunkChange
+	default:
+		return HunkMode(0)
+	}
+}
+
+// OldStart is the first deleted line within the old text, starting at one. For an addition, it is the line after
+// which lines are added, which is zero at the beginning.
+func (hunk Hunk) OldStart() int {
+	return hunk.action.startLine
+}
+
+// NewStart is the first added line within the new text, starting at one. For a deletion, it is the line after which
+// lines were deleted, which is zero at the beginning.
+func (hunk Hunk) NewStart() int {
+	switch hunk.action.mode {
+	case addition:
+		return hunk.action.startLine + hunk.offset + 1
+	case deletion:
+		return hunk.action.startLine + hunk.offset - 1
+	default:
+		return hunk.action.startLine + hunk.offset
+	}
+}
+
+// Deleted lines of the old text, without their newlines.
+func (hunk Hunk) Deleted() []string {
+	return append([]string(nil), hunk.action.deletionLines...)
+}
+
+// Added lines of the new text, without their newlines.
+func (hunk Hunk) Added() []string {
+	return append([]string(nil), hunk.action.additionLines...)
+}
+
+// DeletedNoNewline is true iff the last deleted line had no trailing newline.
+func (hunk Hunk) DeletedNoNewline() bool {
+	return hunk.action.deletionNoNewline
+}
+
+// AddedNoNewline is true iff the last added line has no trailing newline.
+func (hunk Hunk) AddedNoNewline() bool {
+	return hunk.action.additionNoNewline
+}
+
+// String formats this Hunk in the traditional Unix diff format, as Patch.String does. It is empty for an invalid Hunk
+// of a zero-valued patchAction.
+func (hunk Hunk) String() string {
+	if !hunk.action.valid() {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	builder.WriteString(hunk.action.header(hunk.offset))
+	builder.WriteString("\n")
+
+	if hunk.action.mode == deletion || hunk.action.mode == change {
+		patchWriteLines(&builder, "<", hunk.action.deletionLines, hunk.action.deletionNoNewline)
+	}
+	if hunk.action.mode == change {
+		builder.WriteString("---\n")
+	}
+	if hunk.action.mode == addition || hunk.action.mode == change {
+		patchWriteLines(&builder, ">", hunk.action.additionLines, hunk.action.additionNoNewline)
+	}
+
+	return builder.String()
+}
+
+// Hunks calls a function for each Hunk of this Patch in order, until the function returns false.
+func (patch Patch) Hunks(yield func(hunk Hunk) bool) {
+	offset := 0
+	for _, patchAction := range patch {
+		if !yield(Hunk{action: patchAction, offset: offset}) {
+			return
+		}
+		offset += len(patchAction.additionLines) - len(patchAction.deletionLines)
+	}
+}
+
+// PatchStats summarizes the changed lines of a Patch.
+//
+// Changed lines are replaced by another line within a HunkChange. Surplus lines of a HunkChange are counted as added
+// or removed. Thus, all added lines are Added plus Changed and all deleted lines are Removed plus Changed.
+type PatchStats struct {
+	Added   int
+	Removed int
+	Changed int
+}
+
+// Stats of this Patch's changed lines.
+func (patch Patch) Stats() (stats PatchStats) {
+	patch.Hunks(func(hunk Hunk) bool {
+		added, deleted := len(hunk.action.additionLines), len(hunk.action.deletionLines)
+
+		changed := 0
+		if hunk.Mode() == HunkChange {
+			changed = added
+			if deleted < changed {
+				changed = deleted
+			}
+		}
+
+		stats.Added += added - changed
+		stats.Removed += deleted - changed
+		stats.Changed += changed
+		return true
+	})
+	return
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPatchHunks(t *testing.T) {
+	patch, err := parsePatch("0a1,2\n> a\n> b\n3,4d4\n< d\n< e\n6c6,7\n< g\n\\ No newline at end of file\n---\n> G\n> H\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type hunk struct {
+		mode     HunkMode
+		oldStart int
+		newStart int
+		deleted  []string
+		added    []string
+		noNl     bool
+	}
+	expected := []hunk{
+		{HunkAddition, 0, 1, nil, []string{"a", "b"}, false},
+		{HunkDeletion, 3, 4, []string{"d", "e"}, nil, false},
+		{HunkChange, 6, 6, []string{"g"}, []string{"G", "H"}, true},
+	}
+
+	var hunks []hunk
+	var str string
+	patch.Hunks(func(h Hunk) bool {
+		hunks = append(hunks, hunk{h.Mode(), h.OldStart(), h.NewStart(), h.Deleted(), h.Added(), h.DeletedNoNewline()})
+		str += h.String()
+		return true
+	})
+
+	if !reflect.DeepEqual(hunks, expected) {
+		t.Fatalf("expected %v, got %v", expected, hunks)
+	} else if str != patch.String() {
+		t.Fatalf("hunks formatted %q, patch %q", str, patch.String())
+	}
+
+	var count int
+	patch.Hunks(func(Hunk) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Fatalf("iteration did not stop, %d hunks", count)
+	}
+}
+
+func TestPatchHunksZero(t *testing.T) {
+	var hunks []Hunk
+	Patch{patchAction{}}.Hunks(func(h Hunk) bool {
+		hunks = append(hunks, h)
+		return true
+	})
+
+	if len(hunks) != 1 {
+		t.Fatalf("expected one hunk, got %d", len(hunks))
+	} else if mode := hunks[0].Mode(); mode != HunkMode(0) {
+		t.Fatalf("zero-valued hunk has mode %v", mode)
+	} else if str := hunks[0].String(); str != "" {
+		t.Fatalf("zero-valued hunk formatted %q", str)
+	}
+}
+
+func TestPatchStats(t *testing.T) {
+	tests := []struct {
+		oldText string
+		newText string
+		stats   PatchStats
+	}{
+		{"foo\n", "foo\n", PatchStats{}},
+		{"", "a\nb\n", PatchStats{Added: 2}},
+		{"a\nb\nc\n", "a\n", PatchStats{Removed: 2}},
+		{"a\nb\nc\n", "a\nB\nC\nD\n", PatchStats{Added: 1, Changed: 2}},
+		{"a\nb\nc\nd\n", "B\nc\n", PatchStats{Removed: 2, Changed: 1}},
+	}
+
+	for _, test := range tests {
+		if stats := Diff(test.oldText, test.newText).Stats(); stats != test.stats {
+			t.Fatalf("%q to %q: expected %+v, got %+v", test.oldText, test.newText, test.stats, stats)
+		}
+	}
+}
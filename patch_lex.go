@@ -5,10 +5,9 @@
 package pmwiki
 
 import (
+	"bufio"
 	"fmt"
 	"io"
-	"unicode"
-	"unicode/utf8"
 )
 
 // patchLexType are the different tokens of a patchLexItem.
@@ -30,6 +29,8 @@ const (
 	patchAddition
 	// patchDeletion line for a deletion.
 	patchDeletion
+	// patchNoNewline marker, indicating that the previous line had no trailing newline.
+	patchNoNewline
 )
 
 // patchLexItem is a tuple of a patchLexType with its value.
@@ -41,15 +42,15 @@ type patchLexItem struct {
 // patchLexer is a lexer to tokenize a traditional Unix diff file.
 //
 // Its logic and code structure is heavily inspired by Rob Pike's "Lexical Scanning in Go" talk,
-// <https://talks.golang.org/2011/lex.slide>.
+// <https://talks.golang.org/2011/lex.slide>. However, it operates on an io.Reader instead of a string and the states are
+// not run within their own goroutine, but are advanced on demand by nextItem, as the pageFileLexer does.
 type patchLexer struct {
-	data string
+	reader *bufio.Reader
+	// buf holds the bytes read since the last emit or ignore.
+	buf []byte
 
-	start int
-	pos   int
-	width int
-
-	items chan patchLexItem
+	state patchLexStateFunc
+	items []patchLexItem
 }
 
 // patchLexStateFunc is lexing a patchLexer and returns its successive patchLexStateFunc.
@@ -72,7 +73,7 @@ func patchLexBegin(lexer *patchLexer) patchLexStateFunc {
 	defer lexer.backup()
 
 	switch {
-	case unicode.IsDigit(next):
+	case isDigit(next):
 		return patchLexRange
 	case next == '>':
 		return patchLexAdd
@@ -80,6 +81,8 @@ func patchLexBegin(lexer *patchLexer) patchLexStateFunc {
 		return patchLexDel
 	case next == '-':
 		return patchLexDash
+	case next == '\\':
+		return patchLexNoNewline
 	default:
 		return lexer.errorf("unsupported element %c", next)
 	}
@@ -95,7 +98,7 @@ func patchLexRange(lexer *patchLexer) patchLexStateFunc {
 			return lexer.errorf("%v", err)
 		}
 
-		if unicode.IsDigit(next) {
+		if isDigit(next) {
 			continue
 		} else if next == ',' {
 			if hadComma {
@@ -138,6 +141,16 @@ func patchLexDash(lexer *patchLexer) patchLexStateFunc {
 	return patchLexBegin
 }
 
+// patchLexNoNewline reads the "\ No newline at end of file" marker following an addition or deletion line.
+func patchLexNoNewline(lexer *patchLexer) patchLexStateFunc {
+	if err := lexer.expect("\\ No newline at end of file\n"); err != nil {
+		return lexer.errorf("no newline marker errored, %v", err)
+	}
+
+	lexer.ignore()
+	return lexer.emit(patchNoNewline, patchLexBegin)
+}
+
 var (
 	// patchLexAdd reads patchAddition lines.
 	patchLexAdd patchLexStateFunc
@@ -178,85 +191,104 @@ func patchLexAddOrDelGenerator(t patchLexType) patchLexStateFunc {
 		lexer.ignore()
 
 		for {
-			next, err := lexer.next()
-			if err != nil {
+			line, err := lexer.reader.ReadSlice('\n')
+			switch {
+			case err == bufio.ErrBufferFull:
+				lexer.buf = append(lexer.buf, line...)
+
+			case err != nil:
 				return lexer.errorf("%v", err)
-			}
 
-			if next == '\n' {
-				lexer.backup()
+			default:
+				// The trailing newline is consumed as well, unlike other tokens.
+				lexer.buf = append(lexer.buf, line[:len(line)-1]...)
 				return lexer.emit(t, patchLexBegin)
 			}
 		}
 	}
 }
 
-// lexPatch starts a lexical analysis for a diff / patch. The tokens are sent to the channel.
-func lexPatch(data string) <-chan patchLexItem {
-	lexer := &patchLexer{
-		data:  data,
-		items: make(chan patchLexItem),
+// lexPatch starts a lexical analysis for a diff / patch read from an io.Reader. The tokens are requested by nextItem.
+func lexPatch(reader io.Reader) *patchLexer {
+	bufReader, ok := reader.(*bufio.Reader)
+	if !ok {
+		bufReader = bufio.NewReader(reader)
 	}
-	go lexer.run()
 
-	return lexer.items
+	return &patchLexer{
+		reader: bufReader,
+		state:  patchLexBegin,
+	}
 }
 
-// run the patchLexer's states.
-func (lexer *patchLexer) run() {
-	for stage := patchLexBegin; stage != nil; stage = stage(lexer) {
+// nextItem runs the patchLexer's states until the next token was emitted. After the final patchEOF or patchError, ok
+// is false and only patchEOFs are returned.
+func (lexer *patchLexer) nextItem() (item patchLexItem, ok bool) {
+	for len(lexer.items) == 0 {
+		if lexer.state == nil {
+			return patchLexItem{patchEOF, ""}, false
+		}
+		lexer.state = lexer.state(lexer)
 	}
-	close(lexer.items)
+
+	item = lexer.items[0]
+	lexer.items = append(lexer.items[:0], lexer.items[1:]...)
+	return item, true
 }
 
-// next rune from data.
-func (lexer *patchLexer) next() (r rune, err error) {
-	if lexer.pos >= len(lexer.data) {
-		return 0, io.EOF
+// next byte from the reader. Bytes are used instead of runes, because legacy diffs are not necessarily UTF-8 encoded.
+func (lexer *patchLexer) next() (b byte, err error) {
+	if b, err = lexer.reader.ReadByte(); err == nil {
+		lexer.buf = append(lexer.buf, b)
 	}
-
-	r, lexer.width = utf8.DecodeRuneInString(lexer.data[lexer.pos:])
-	lexer.pos += lexer.width
 	return
 }
 
+// isDigit checks if a byte is an ASCII digit.
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
 // expect the following string to occur in the data.
 func (lexer *patchLexer) expect(expected string) error {
-	for i, c := range expected {
+	for i := 0; i < len(expected); i++ {
 		if next, err := lexer.next(); err != nil {
 			return err
-		} else if next != c {
-			return fmt.Errorf("expected string received %c instead of %c at position %d", next, c, i)
+		} else if next != expected[i] {
+			return fmt.Errorf("expected string received %c instead of %c at position %d", next, expected[i], i)
 		}
 	}
 	return nil
 }
 
-// backup the last rune.
+// backup the last byte.
 func (lexer *patchLexer) backup() {
-	lexer.pos -= lexer.width
+	if err := lexer.reader.UnreadByte(); err != nil {
+		panic(err)
+	}
+	lexer.buf = lexer.buf[:len(lexer.buf)-1]
 }
 
 // ignore the read data since the last emit / ignore.
 func (lexer *patchLexer) ignore() {
-	lexer.start = lexer.pos
+	lexer.buf = lexer.buf[:0]
 }
 
-// emit the read data to the channel.
+// emit the read data as the next token.
 func (lexer *patchLexer) emit(t patchLexType, succ patchLexStateFunc) patchLexStateFunc {
-	lexer.items <- patchLexItem{t, lexer.data[lexer.start:lexer.pos]}
-	lexer.start = lexer.pos
+	lexer.items = append(lexer.items, patchLexItem{t, string(lexer.buf)})
+	lexer.buf = lexer.buf[:0]
 	return succ
 }
 
 // errorf emits an error back.
 func (lexer *patchLexer) errorf(format string, args ...interface{}) patchLexStateFunc {
-	lexer.items <- patchLexItem{patchError, fmt.Sprintf(format, args...)}
+	lexer.items = append(lexer.items, patchLexItem{patchError, fmt.Sprintf(format, args...)})
 	return nil
 }
 
 // eof emits an patchEOF.
 func (lexer *patchLexer) eof() patchLexStateFunc {
-	lexer.items <- patchLexItem{patchEOF, ""}
+	lexer.items = append(lexer.items, patchLexItem{patchEOF, ""})
 	return nil
 }
@@ -6,6 +6,7 @@ package pmwiki
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -114,6 +115,37 @@ func TestLexPatchValid(t *testing.T) {
 		{patchEOF, ""},
 	}
 
+	input10 := "1c1\n< foo\n\\ No newline at end of file\n---\n> bar\n\\ No newline at end of file\n"
+	items10 := []patchLexItem{
+		{patchRange, "1"},
+		{patchMode, "c"},
+		{patchRange, "1"},
+		{patchDeletion, "foo"},
+		{patchNoNewline, ""},
+		{patchAddition, "bar"},
+		{patchNoNewline, ""},
+		{patchEOF, ""},
+	}
+
+	longLine := strings.Repeat("lorem ipsum ", 1000)
+	input11 := "0a1\n> " + longLine + "\n"
+	items11 := []patchLexItem{
+		{patchRange, "0"},
+		{patchMode, "a"},
+		{patchRange, "1"},
+		{patchAddition, longLine},
+		{patchEOF, ""},
+	}
+
+	input12 := "1d0\n< \261\262\n"
+	items12 := []patchLexItem{
+		{patchRange, "1"},
+		{patchMode, "d"},
+		{patchRange, "0"},
+		{patchDeletion, "\261\262"},
+		{patchEOF, ""},
+	}
+
 	tests := []struct {
 		name  string
 		input string
@@ -128,12 +160,16 @@ func TestLexPatchValid(t *testing.T) {
 		{"simple deletion", input7, items7},
 		{"simple change", input8, items8},
 		{"Wikipedia example", input9, items9},
+		{"no newline marker", input10, items10},
+		{"line exceeding buffer", input11, items11},
+		{"non UTF-8 bytes", input12, items12},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			var items []patchLexItem
-			for item := range lexPatch(test.input) {
+			lexer := lexPatch(strings.NewReader(test.input))
+			for item, ok := lexer.nextItem(); ok; item, ok = lexer.nextItem() {
 				items = append(items, item)
 			}
 
@@ -168,11 +204,13 @@ func TestLexPatchInvalid(t *testing.T) {
 		{"addition early end", "> addition"},
 		{"deletion early end", "< deletion"},
 		{"double dash", "--"},
+		{"invalid no newline marker", "\\ No newline"},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			for item := range lexPatch(test.input) {
+			lexer := lexPatch(strings.NewReader(test.input))
+			for item, ok := lexer.nextItem(); ok; item, ok = lexer.nextItem() {
 				if item.t == patchError {
 					return
 				}
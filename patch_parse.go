@@ -5,21 +5,33 @@
 package pmwiki
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 )
 
-// patchParser parses a Patch based on a patchLexer's channel.
+// patchParser parses a Patch based on a patchLexer's tokens.
 type patchParser struct {
 	patches []patchAction
 	err     error
 
-	lexBuff  []patchLexItem
-	lexItems <-chan patchLexItem
+	lexBuff []patchLexItem
+	lexer   *patchLexer
 
 	nextPatch *patchAction
+
+	// strict parsing validates each header's declared ranges against its lines, see parsePatchStrict.
+	strict bool
+	// line of the current input line, header of the current patchAction, and its declared old and new ranges.
+	line       int
+	headerLine int
+	oldRange   [2]int
+	newRange   [2]int
+	// offset is the line difference caused by all previous patchActions, oldEnd the end of the previous old range.
+	offset int
+	oldEnd int
 }
 
 type patchParseStateFunc func(*patchParser) patchParseStateFunc
@@ -30,7 +42,7 @@ func (parser *patchParser) next() (item patchLexItem) {
 		item = parser.lexBuff[len(parser.lexBuff)-1]
 		parser.lexBuff = parser.lexBuff[:len(parser.lexBuff)-1]
 	} else {
-		item = <-parser.lexItems
+		item, _ = parser.lexer.nextItem()
 	}
 
 	return
@@ -49,7 +61,7 @@ func (parser *patchParser) nextType(lexType patchLexType, max int) (v string, er
 		} else if item.t == patchEOF {
 			return "", io.EOF
 		} else if item.t == patchError {
-			return "", fmt.Errorf(item.v)
+			return "", errors.New(item.v)
 		}
 	}
 	return "", fmt.Errorf("no item with type %v found in %d messages", lexType, max)
@@ -66,12 +78,79 @@ func (parser *patchParser) errorf(format string, args ...interface{}) patchParse
 // emit the current patch action.
 func (parser *patchParser) emit(succ patchParseStateFunc) patchParseStateFunc {
 	if parser.nextPatch != nil {
+		if parser.strict {
+			if err := parser.validate(); err != nil {
+				return parser.errorf("hunk %d at line %d: %w", len(parser.patches)+1, parser.headerLine, err)
+			}
+		}
 		parser.patches = append(parser.patches, *parser.nextPatch)
 	}
 
 	return succ
 }
 
+// validate the current patch action against its header's declared ranges.
+func (parser *patchParser) validate() error {
+	patchAction := parser.nextPatch
+	oldCount := parser.oldRange[1] - parser.oldRange[0] + 1
+	newCount := parser.newRange[1] - parser.newRange[0] + 1
+
+	switch {
+	case oldCount < 1 || newCount < 1:
+		return fmt.Errorf("declared range ends before it starts")
+
+	case patchAction.mode == addition && oldCount != 1:
+		return fmt.Errorf("addition declares %d old lines instead of a single line", oldCount)
+	case patchAction.mode == deletion && newCount != 1:
+		return fmt.Errorf("deletion declares %d new lines instead of a single line", newCount)
+
+	case patchAction.mode != addition && oldCount != len(patchAction.deletionLines):
+		return fmt.Errorf("declared %d deleted lines, got %d", oldCount, len(patchAction.deletionLines))
+	case patchAction.mode != deletion && newCount != len(patchAction.additionLines):
+		return fmt.Errorf("declared %d added lines, got %d", newCount, len(patchAction.additionLines))
+	}
+
+	oldStart := patchAction.startLine
+	if patchAction.mode != addition {
+		oldStart--
+	}
+	if oldStart < parser.oldEnd {
+		return fmt.Errorf("old line %d overlaps the previous hunk ending at line %d", patchAction.startLine, parser.oldEnd)
+	}
+
+	if newStart := (Hunk{action: *patchAction, offset: parser.offset}).NewStart(); newStart != parser.newRange[0] {
+		return fmt.Errorf("declared new line %d, expected %d", parser.newRange[0], newStart)
+	}
+
+	parser.oldEnd = oldStart + len(patchAction.deletionLines)
+	parser.offset += len(patchAction.additionLines) - len(patchAction.deletionLines)
+	return nil
+}
+
+// patchParseRange parses a header's range, e.g., 2 or 2,5, into its first and last line.
+func patchParseRange(lineRange string) (start, end int, err error) {
+	parts := strings.Split(lineRange, ",")
+	if start, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+
+	end = start
+	if len(parts) > 1 {
+		end, err = strconv.Atoi(parts[1])
+	}
+	return
+}
+
+// parseRange parses a header's range as patchParseRange does. Unless being strict, only its first line is parsed.
+func (parser *patchParser) parseRange(lineRange string) (start, end int, err error) {
+	if parser.strict {
+		return patchParseRange(lineRange)
+	}
+
+	start, err = strconv.Atoi(strings.Split(lineRange, ",")[0])
+	return start, start, err
+}
+
 // patchParseStart parses the begin of a new item, an EOF, or an error.
 func patchParseStart(parser *patchParser) patchParseStateFunc {
 	switch next := parser.next(); next.t {
@@ -93,13 +172,16 @@ func patchParseStart(parser *patchParser) patchParseStateFunc {
 // patchParseHeader parses the patch header with the modified lines and mode.
 func patchParseHeader(parser *patchParser) (succ patchParseStateFunc) {
 	parser.nextPatch = new(patchAction)
+	parser.line++
+	parser.headerLine = parser.line
 
 	if startRange, err := parser.nextType(patchRange, 1); err != nil {
 		return parser.errorf("%w", err)
-	} else if start, err := strconv.Atoi(strings.Split(startRange, ",")[0]); err != nil {
+	} else if start, end, err := parser.parseRange(startRange); err != nil {
 		return parser.errorf("cannot parse start range, %w", err)
 	} else {
 		parser.nextPatch.startLine = start
+		parser.oldRange = [2]int{start, end}
 	}
 
 	if mode, err := parser.nextType(patchMode, 1); err != nil {
@@ -120,8 +202,15 @@ func patchParseHeader(parser *patchParser) (succ patchParseStateFunc) {
 		}
 	}
 
-	if _, err := parser.nextType(patchRange, 1); err != nil {
+	// The end range is only used for validation. Thus, an invalid or missing one is accepted unless being strict.
+	if endRange, err := parser.nextType(patchRange, 1); err != nil {
 		return parser.errorf("%w", err)
+	} else if !parser.strict {
+		return
+	} else if start, end, err := patchParseRange(endRange); err != nil {
+		return parser.errorf("cannot parse end range, %w", err)
+	} else {
+		parser.newRange = [2]int{start, end}
 	}
 
 	return
@@ -130,8 +219,13 @@ func patchParseHeader(parser *patchParser) (succ patchParseStateFunc) {
 // patchParseAddition parses addition lines.
 func patchParseAddition(parser *patchParser) patchParseStateFunc {
 	next := parser.next()
-	for ; next.t == patchAddition; next = parser.next() {
-		parser.nextPatch.additionLines = append(parser.nextPatch.additionLines, next.v)
+	for ; next.t == patchAddition || next.t == patchNoNewline; next = parser.next() {
+		parser.line++
+		if next.t == patchNoNewline {
+			parser.nextPatch.additionNoNewline = true
+		} else {
+			parser.nextPatch.additionLines = append(parser.nextPatch.additionLines, next.v)
+		}
 	}
 
 	parser.backup(next)
@@ -141,23 +235,41 @@ func patchParseAddition(parser *patchParser) patchParseStateFunc {
 // patchParseDeletion parses deletion lines and might switch to patchParseAddition in case of a change patchAction.
 func patchParseDeletion(parser *patchParser) patchParseStateFunc {
 	next := parser.next()
-	for ; next.t == patchDeletion; next = parser.next() {
-		parser.nextPatch.deletionLines = append(parser.nextPatch.deletionLines, next.v)
+	for ; next.t == patchDeletion || next.t == patchNoNewline; next = parser.next() {
+		parser.line++
+		if next.t == patchNoNewline {
+			parser.nextPatch.deletionNoNewline = true
+		} else {
+			parser.nextPatch.deletionLines = append(parser.nextPatch.deletionLines, next.v)
+		}
 	}
 
 	parser.backup(next)
 	if parser.nextPatch.mode == change && next.t == patchAddition {
+		// the dashes between deletion and addition lines
+		parser.line++
 		return patchParseAddition
 	} else {
 		return patchParseStart
 	}
 }
 
-// parsePatch parses a Patch from an input string.
-func parsePatch(data string) (Patch, error) {
-	parser := &patchParser{lexItems: lexPatch(data)}
+// parsePatchReader parses a Patch read from an io.Reader, optionally validated as by parsePatchStrict.
+func parsePatchReader(r io.Reader, strict bool) (Patch, error) {
+	parser := &patchParser{lexer: lexPatch(r), strict: strict}
 	for state := patchParseStart; state != nil; state = state(parser) {
 	}
 
 	return parser.patches, parser.err
 }
+
+// parsePatch parses a Patch from an input string.
+func parsePatch(data string) (Patch, error) {
+	return parsePatchReader(strings.NewReader(data), false)
+}
+
+// parsePatchStrict parses a Patch like parsePatch, but validates each header's declared line ranges against the
+// amount of lines, the line ranges' order, and the consistency of the old and new lines.
+func parsePatchStrict(data string) (Patch, error) {
+	return parsePatchReader(strings.NewReader(data), true)
+}
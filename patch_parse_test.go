@@ -5,6 +5,7 @@
 package pmwiki
 
 import (
+	"runtime"
 	"testing"
 )
 
@@ -50,6 +51,26 @@ func TestParsePatchValid(t *testing.T) {
 			patch[3].mode == addition && patch[3].startLine == 24 && len(patch[3].additionLines) == 4
 	}
 
+	input7 := "1c1\n< foo\n\\ No newline at end of file\n---\n> bar\n"
+	check7 := func(patch Patch) bool {
+		return len(patch) == 1 && patch[0].mode == change &&
+			len(patch[0].deletionLines) == 1 && patch[0].deletionNoNewline &&
+			len(patch[0].additionLines) == 1 && !patch[0].additionNoNewline
+	}
+
+	// Headers without an end range are accepted unless being strict.
+	input8 := "5a\n> foo\n"
+	check8 := func(patch Patch) bool {
+		return len(patch) == 1 && patch[0].mode == addition && patch[0].startLine == 5 &&
+			len(patch[0].additionLines) == 1 && patch[0].additionLines[0] == "foo"
+	}
+
+	input9 := "1c\n< a\n---\n> b\n"
+	check9 := func(patch Patch) bool {
+		return len(patch) == 1 && patch[0].mode == change && patch[0].startLine == 1 &&
+			len(patch[0].deletionLines) == 1 && len(patch[0].additionLines) == 1
+	}
+
 	tests := []struct {
 		name  string
 		input string
@@ -61,6 +82,9 @@ func TestParsePatchValid(t *testing.T) {
 		{"single deletion", input4, check4},
 		{"single change", input5, check5},
 		{"Wikipedia example", input6, check6},
+		{"no newline marker", input7, check7},
+		{"addition without end range", input8, check8},
+		{"change without end range", input9, check9},
 	}
 
 	for _, test := range tests {
@@ -90,6 +114,7 @@ func TestParsePatchInvalid(t *testing.T) {
 		{"deletion only", "< deletion\n"},
 		{"double dash only", "--\n"},
 		{"range-mode-range-mode", "0a1a1\n"},
+		{"no newline marker only", "\\ No newline at end of file\n"},
 	}
 
 	for _, test := range tests {
@@ -100,3 +125,59 @@ func TestParsePatchInvalid(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePatchStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"addition", "0a1,2\n> a\n> b\n", true},
+		{"deletion", "1,2d0\n< a\n< b\n", true},
+		{"change", "2c2,3\n< b\n---\n> B\n> C\n", true},
+		{"multiple", "1d0\n< a\n3c2\n< c\n---\n> C\n5a5\n> f\n", true},
+		{"no newline", "1c1\n< a\n\\ No newline at end of file\n---\n> A\n", true},
+		{"too few deletions", "1,3d0\n< a\n< b\n", false},
+		{"too many additions", "0a1\n> a\n> b\n", false},
+		{"addition with old range", "1,2a3\n> c\n", false},
+		{"deletion with new range", "1d0,1\n< a\n", false},
+		{"reversed range", "3,1d0\n< a\n", false},
+		{"wrong new start", "1d0\n< a\n3c3\n< c\n---\n> C\n", false},
+		{"overlapping hunks", "2d1\n< b\n2d0\n< b\n", false},
+		{"addition without end range", "5a\n> foo\n", false},
+		{"change without end range", "1c\n< a\n---\n> b\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parsePatch(test.input); err != nil {
+				t.Fatalf("lenient parsing failed, %v", err)
+			}
+
+			if patch, err := parsePatchStrict(test.input); test.valid && err != nil {
+				t.Fatal(err)
+			} else if !test.valid && err == nil {
+				t.Fatalf("did not fail, produced %v", patch)
+			} else if test.valid && patch.String() != test.input {
+				t.Fatalf("expected %q, got %q", test.input, patch.String())
+			}
+		})
+	}
+}
+
+func TestParsePatchNoGoroutineLeak(t *testing.T) {
+	inputs := []string{"1x", "1a2\n> foo\n| bar\n> baz\n", "1,2d0\n< foo\n2a3\n> bar\n"}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		for _, input := range inputs {
+			if _, err := parsePatchStrict(input); err == nil {
+				t.Fatalf("parsing %q did not fail", input)
+			}
+		}
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("%d goroutines before parsing, %d afterwards", before, after)
+	}
+}
@@ -5,6 +5,8 @@
 package pmwiki
 
 import (
+	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -60,7 +62,7 @@ func TestPatchApplyPmWiki(t *testing.T) {
 	}
 
 	text := pageFile.Text
-	for rev := pageFile.Revs[pageFile.Time]; text != ""; rev = pageFile.Revs[rev.DiffAgainst] {
+	for rev, _ := pageFile.Revision(pageFile.Time); text != ""; rev, _ = pageFile.Revision(rev.DiffAgainst) {
 		var textOut strings.Builder
 		if err := rev.Diff.Apply(strings.NewReader(text), &textOut); err != nil {
 			t.Fatal(err)
@@ -68,3 +70,129 @@ func TestPatchApplyPmWiki(t *testing.T) {
 		text = textOut.String()
 	}
 }
+
+func TestPatchInvert(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	words := []string{"", "foo", "bar", "buz", "qux"}
+
+	randomText := func() string {
+		lines := make([]string, random.Intn(20))
+		for i := range lines {
+			lines[i] = words[random.Intn(len(words))]
+		}
+		if random.Intn(2) == 0 {
+			return strings.Join(lines, "\n") + "\n"
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for i := 0; i < 1000; i++ {
+		oldText, newText := randomText(), randomText()
+
+		var out strings.Builder
+		if err := Diff(oldText, newText).Invert().Apply(strings.NewReader(newText), &out); err != nil {
+			t.Fatal(err)
+		} else if out.String() != oldText {
+			t.Fatalf("inverted patching %q to %q resulted in %q", newText, oldText, out.String())
+		}
+	}
+}
+
+func TestPatchInvertString(t *testing.T) {
+	tests := []struct {
+		reverse string
+		forward string
+	}{
+		{"1c1\n< b\n---\n> a\n", "1c1\n< a\n---\n> b\n"},
+		{"2,3d1\n< b\n< c\n", "1a2,3\n> b\n> c\n"},
+		{"0a1\n> a\n3c4\n< c\n\\ No newline at end of file\n---\n> d\n", "1d0\n< a\n4c3\n< d\n---\n> c\n\\ No newline at end of file\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.reverse, func(t *testing.T) {
+			patch, err := parsePatch(test.reverse)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if forward := patch.Invert().String(); forward != test.forward {
+				t.Fatalf("expected %q, got %q", test.forward, forward)
+			} else if reverse := patch.Invert().Invert().String(); reverse != test.reverse {
+				t.Fatalf("double inversion resulted in %q", reverse)
+			}
+		})
+	}
+}
+
+func TestPatchApplyStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		input string
+		valid bool
+	}{
+		{"valid", "2c2\n< b\n---\n> B\n", "a\nb\nc\n", true},
+		{"append", "3a4\n> d\n", "a\nb\nc\n", true},
+		{"deletion exceeds input", "2,4d1\n< b\n< c\n< d\n", "a\nb\nc\n", false},
+		{"addition exceeds input", "5a6\n> f\n", "a\nb\nc\n", false},
+		{"deletion after input", "5d4\n< e\n", "a\nb\nc\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch, err := parsePatch(test.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out strings.Builder
+			if err := patch.Apply(strings.NewReader(test.input), &out); err != nil {
+				t.Fatalf("lenient applying failed, %v", err)
+			}
+
+			out.Reset()
+			if err := patch.Apply(strings.NewReader(test.input), &out, WithStrictApply()); (err == nil) != test.valid {
+				t.Fatalf("unexpected result, %v", err)
+			}
+		})
+	}
+}
+
+func TestPatchApplyReport(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		input string
+		fuzz  []ApplyFuzz
+	}{
+		{"exact", "2c2\n< b\n---\n> B\n", "a\nb\nc\n", nil},
+		{"trailing whitespace", "2c2\n< b  \n---\n> B\n", "a\nb\nc\n",
+			[]ApplyFuzz{{Hunk: 1, Line: 2, Expected: "b  ", Actual: "b"}}},
+		{"multiple hunks", "1d0\n< \ta\n3,4c2,3\n< c\n<  d\n---\n> C\n> D\n", "a\nb\nc\nd\n",
+			[]ApplyFuzz{
+				{Hunk: 1, Line: 1, Expected: "\ta", Actual: "a"},
+				{Hunk: 2, Line: 4, Expected: " d", Actual: "d"},
+			}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch, err := parsePatch(test.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := ApplyReport{Fuzz: []ApplyFuzz{{Hunk: 23}}}
+			var out strings.Builder
+			if err := patch.Apply(strings.NewReader(test.input), &out, WithApplyReport(&report)); err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Fuzzy() != (len(test.fuzz) > 0) {
+				t.Fatalf("report is fuzzy: %t, expected %d fuzzy matches", report.Fuzzy(), len(test.fuzz))
+			} else if !reflect.DeepEqual(report.Fuzz, test.fuzz) {
+				t.Fatalf("report %#v differs from expected %#v", report.Fuzz, test.fuzz)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// unifiedRange formats a line range for a unified diff's hunk header. The start is zero-based. An empty range is
+// identified by the line before it, as GNU diff does.
+func unifiedRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// unifiedWriteLines writes the lines prefixed by the marker, followed by an optional "No newline" marker.
+func unifiedWriteLines(w *bufio.Writer, marker string, lines []string, noNewline bool) {
+	for _, line := range lines {
+		w.WriteString(marker)
+		w.WriteString(line)
+		w.WriteString("\n")
+	}
+	if noNewline && len(lines) > 0 {
+		w.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+// WriteUnified writes this Patch as a unified diff, e.g., to be reviewed by common tools or to be applied by patch.
+//
+// The oldText is the text this Patch applies to, providing up to context unchanged lines around each change. Changes
+// with overlapping context are joined into one hunk. The oldName and newName are used for the file header. Nothing is
+// written for an empty Patch, just like diff does for equal files.
+//
+// An error is returned if the deleted lines do not match the oldText. As for Patch.Apply, trimmed whitespaces are
+// tolerated; the deleted lines are written as within the oldText.
+func (patch Patch) WriteUnified(w io.Writer, oldName, newName string, context int, oldText string) error {
+	if len(patch) == 0 {
+		return nil
+	}
+	if context < 0 {
+		context = 0
+	}
+
+	lines := diffSplit(oldText)
+
+	// starts and ends of each patchAction's old lines, zero-based and half-open
+	starts, ends := make([]int, len(patch)), make([]int, len(patch))
+	for i, patchAction := range patch {
+		if !patchAction.valid() {
+			return fmt.Errorf("patch %d has no valid mode", i)
+		}
+
+		if patchAction.mode == addition {
+			starts[i] = patchAction.startLine
+		} else {
+			starts[i] = patchAction.startLine - 1
+		}
+		ends[i] = starts[i] + len(patchAction.deletionLines)
+
+		if starts[i] < 0 || ends[i] > len(lines) || (i > 0 && starts[i] < ends[i-1]) {
+			return fmt.Errorf("patch %d does not fit into the old text of %d lines", i, len(lines))
+		}
+
+		// As for Patch.Apply, deleted lines might only match after trimming whitespaces.
+		for j, expected := range patchAction.deletionLines {
+			if line := lines[starts[i]+j].text; line != expected && line != strings.TrimSpace(expected) {
+				return fmt.Errorf("patch %d expected \"%s\" in line %d, got \"%s\"", i, expected, starts[i]+j+1, line)
+			}
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "--- %s\n+++ %s\n", oldName, newName)
+
+	offset := 0
+	for first := 0; first < len(patch); {
+		last := first
+		for last+1 < len(patch) && starts[last+1]-ends[last] <= 2*context {
+			last++
+		}
+
+		hunkStart, hunkEnd := starts[first]-context, ends[last]+context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		if hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+
+		newCount := hunkEnd - hunkStart
+		for _, patchAction := range patch[first : last+1] {
+			newCount += len(patchAction.additionLines) - len(patchAction.deletionLines)
+		}
+		fmt.Fprintf(writer, "@@ -%s +%s @@\n",
+			unifiedRange(hunkStart, hunkEnd-hunkStart), unifiedRange(hunkStart+offset, newCount))
+
+		writeContext := func(from, to int) {
+			for _, line := range lines[from:to] {
+				unifiedWriteLines(writer, " ", []string{line.text}, line.noNewline)
+			}
+		}
+
+		pos := hunkStart
+		for i := first; i <= last; i++ {
+			writeContext(pos, starts[i])
+
+			patchAction := patch[i]
+			for _, line := range lines[starts[i]:ends[i]] {
+				unifiedWriteLines(writer, "-", []string{line.text}, line.noNewline)
+			}
+			if patchAction.mode == addition || patchAction.mode == change {
+				unifiedWriteLines(writer, "+", patchAction.additionLines, patchAction.additionNoNewline)
+			}
+
+			pos = ends[i]
+			offset += len(patchAction.additionLines) - len(patchAction.deletionLines)
+		}
+		writeContext(pos, hunkEnd)
+
+		first = last + 1
+	}
+
+	return writer.Flush()
+}
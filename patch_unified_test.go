@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPatchWriteUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		context int
+		unified string
+	}{
+		{"equal", "foo\n", "foo\n", 3, ""},
+		{"separate hunks", "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n", "a\nB\nc\nd\ne\nf\ng\nh\nI\nj\n", 1,
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n@@ -8,3 +8,3 @@\n h\n-i\n+I\n j\n"},
+		{"joined hunks", "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n", "a\nB\nc\nd\ne\nf\ng\nh\nI\nj\n", 3,
+			"--- old\n+++ new\n@@ -1,10 +1,10 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n h\n-i\n+I\n j\n"},
+		{"creation", "", "foo\nbar", 3,
+			"--- old\n+++ new\n@@ -0,0 +1,2 @@\n+foo\n+bar\n\\ No newline at end of file\n"},
+		{"no newline", "foo\nbar", "foo\nbaz\n", 3,
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n foo\n-bar\n\\ No newline at end of file\n+baz\n"},
+		{"no context", "a\nb\nc\n", "a\nc\n", 0,
+			"--- old\n+++ new\n@@ -2 +1,0 @@\n-b\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := Diff(test.oldText, test.newText).WriteUnified(&out, "old", "new", test.context, test.oldText); err != nil {
+				t.Fatal(err)
+			} else if out.String() != test.unified {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.unified, out.String())
+			}
+		})
+	}
+}
+
+func TestPatchWriteUnifiedTrimmed(t *testing.T) {
+	// As for Patch.Apply, the deleted line "a " matches the old text's "a" after trimming whitespaces.
+	patch, err := parsePatch("1c1\n< a \n---\n> b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := patch.WriteUnified(&out, "old", "new", 3, "a\n"); err != nil {
+		t.Fatal(err)
+	} else if expected := "--- old\n+++ new\n@@ -1 +1 @@\n-a\n+b\n"; out.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestPatchWriteUnifiedInvalid(t *testing.T) {
+	patch, err := parsePatch("5d4\n< foo\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := patch.WriteUnified(&out, "old", "new", 3, "foo\n"); err == nil {
+		t.Fatal("patch exceeding the old text was accepted")
+	}
+
+	if err := Diff("foo\n", "").WriteUnified(&out, "old", "new", 3, "bar\n"); err == nil {
+		t.Fatal("patch not matching the old text was accepted")
+	}
+
+	if err := make(Patch, 1).WriteUnified(&out, "old", "new", 3, "foo\n"); err == nil {
+		t.Fatal("zero-valued action was accepted")
+	}
+}
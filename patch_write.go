@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"fmt"
+	"strings"
+)
+
+// patchFormatRange formats a line range for a diff / patch header, e.g., 2 or 2,5.
+func patchFormatRange(start, end int) string {
+	if end <= start {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, end)
+}
+
+// valid checks if this patchAction has a mode, which is missing for a zero value.
+func (patchAction patchAction) valid() bool {
+	return patchAction.mode == addition || patchAction.mode == deletion || patchAction.mode == change
+}
+
+// header of this patchAction. The offset is the line difference caused by all previous patchActions. It is empty for
+// an invalid patchAction.
+func (patchAction patchAction) header(offset int) string {
+	oldStart, oldEnd := patchAction.startLine, patchAction.startLine+len(patchAction.deletionLines)-1
+
+	switch patchAction.mode {
+	case addition:
+		newStart := oldStart + offset + 1
+		return fmt.Sprintf("%da%s", oldStart, patchFormatRange(newStart, newStart+len(patchAction.additionLines)-1))
+
+	case deletion:
+		return fmt.Sprintf("%sd%d", patchFormatRange(oldStart, oldEnd), oldStart+offset-1)
+
+	case change:
+		newStart := oldStart + offset
+		return fmt.Sprintf("%sc%s", patchFormatRange(oldStart, oldEnd), patchFormatRange(newStart, newStart+len(patchAction.additionLines)-1))
+
+	default:
+		return ""
+	}
+}
+
+// patchWriteLines writes the lines prefixed by the marker, followed by an optional "No newline" marker.
+func patchWriteLines(builder *strings.Builder, marker string, lines []string, noNewline bool) {
+	for _, line := range lines {
+		builder.WriteString(marker)
+		builder.WriteString(" ")
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+	if noNewline {
+		builder.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+// String formats this Patch in the traditional Unix diff format, as being stored within a PageFile.
+//
+// The header's line ranges are calculated from the patchActions. Thus, a parsed Patch is formatted identically, as
+// long as its header was consistent. Invalid patchActions without a mode, e.g., zero values, are skipped.
+func (patch Patch) String() string {
+	var builder strings.Builder
+	patch.Hunks(func(hunk Hunk) bool {
+		builder.WriteString(hunk.String())
+		return true
+	})
+	return builder.String()
+}
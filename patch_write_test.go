@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2020 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package pmwiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPatchString(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty patch", ""},
+		{"single addition", "0a1\n> addition\n"},
+		{"multiline addition", "0a1,3\n> multiline\n> addition\n> yay\n"},
+		{"single deletion", "23d22\n< gone\n"},
+		{"multiline deletion", "1,27d0\n" + strings.Repeat("< foo\n", 27)},
+		{"single change", "5c5\n< foo\n---\n> bar\n"},
+		{"range change", "19,20c19\n< foo\n< bar\n---\n> buz\n"},
+		{"empty lines", "2c2\n< \n---\n> \n"},
+		{"no newline", "6c6\n< foo\n\\ No newline at end of file\n---\n> bar\n\\ No newline at end of file\n"},
+		{"Wikipedia example", "0a1,6\n> This is an important\n> notice! It should\n> therefore be located at\n" +
+			"> the beginning of this\n> document!\n> \n11,15d16\n< This paragraph contains\n" +
+			"< text that is outdated.\n< It will be deleted in the\n< near future.\n< \n17c18\n" +
+			"< check this dokument. On\n---\n> check this document. On\n24a26,29\n> \n" +
+			"> This paragraph contains\n> important new additions\n> to this document.\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if patch, err := parsePatch(test.input); err != nil {
+				t.Fatal(err)
+			} else if s := patch.String(); s != test.input {
+				t.Fatalf("expected %q, got %q", test.input, s)
+			}
+		})
+	}
+}
+
+func TestPatchStringZeroValue(t *testing.T) {
+	if s := make(Patch, 1).String(); s != "" {
+		t.Fatalf("zero-valued action was formatted as %q", s)
+	}
+
+	patch, err := parsePatch("1d0\n< foo\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch = append(Patch{{}}, patch...)
+	if s := patch.String(); s != "1d0\n< foo\n" {
+		t.Fatalf("unexpected %q", s)
+	}
+}